@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// Minimum time between samples for computing rates. States often arrive in quick
+// succession after user actions, and rates over tiny intervals are just noise.
+const minBandwidthSampleInterval = 1 * time.Second
+
+// How far back to keep total rates for the throughput graph.
+const throughputHistoryWindow = 5 * time.Minute
+
+// Total transfer rates at a point in time.
+type rateSample struct {
+	time   time.Time
+	rxRate float64
+	txRate float64
+}
+
+// Current transfer rate of a single peer.
+type peerRate struct {
+	peer *ipnstate.PeerStatus
+	// Bytes per second received from the peer.
+	rxRate float64
+	// Bytes per second sent to the peer.
+	txRate float64
+}
+
+// Tracks per-peer byte counters across state updates and computes transfer rates
+// from the differences between them.
+type bandwidthTracker struct {
+	// Time of the last sample, or zero if there hasn't been one.
+	lastSampleTime time.Time
+	// Byte counters per peer as of the last sample.
+	lastRxBytes map[tailcfg.StableNodeID]int64
+	lastTxBytes map[tailcfg.StableNodeID]int64
+
+	// Per-peer rates as of the last sample, sorted by total throughput, highest first.
+	rates []peerRate
+	// Total bytes per second received from all peers.
+	totalRxRate float64
+	// Total bytes per second sent to all peers.
+	totalTxRate float64
+	// Total rates over the last throughputHistoryWindow, oldest first.
+	history []rateSample
+}
+
+// Take a new sample of the peers' byte counters and recompute the rates.
+func (t *bandwidthTracker) update(peers []*ipnstate.PeerStatus, now time.Time) {
+	if !t.lastSampleTime.IsZero() && now.Sub(t.lastSampleTime) < minBandwidthSampleInterval {
+		return
+	}
+
+	elapsed := now.Sub(t.lastSampleTime).Seconds()
+	isFirstSample := t.lastSampleTime.IsZero()
+
+	rxBytes := make(map[tailcfg.StableNodeID]int64, len(peers))
+	txBytes := make(map[tailcfg.StableNodeID]int64, len(peers))
+	rates := make([]peerRate, 0, len(peers))
+	var totalRxRate, totalTxRate float64
+
+	for _, peer := range peers {
+		rxBytes[peer.ID] = peer.RxBytes
+		txBytes[peer.ID] = peer.TxBytes
+
+		rate := peerRate{peer: peer}
+
+		// New peers and counters that went backwards (tailscaled restarted) don't have a
+		// meaningful rate until the next sample.
+		lastRx, rxOk := t.lastRxBytes[peer.ID]
+		lastTx, txOk := t.lastTxBytes[peer.ID]
+		if !isFirstSample && rxOk && txOk && peer.RxBytes >= lastRx && peer.TxBytes >= lastTx {
+			rate.rxRate = float64(peer.RxBytes-lastRx) / elapsed
+			rate.txRate = float64(peer.TxBytes-lastTx) / elapsed
+		}
+
+		totalRxRate += rate.rxRate
+		totalTxRate += rate.txRate
+		rates = append(rates, rate)
+	}
+
+	// Sort by throughput, falling back to lifetime traffic so idle peers still have a
+	// stable and useful order.
+	slices.SortStableFunc(rates, func(a, b peerRate) int {
+		if c := cmp.Compare(b.rxRate+b.txRate, a.rxRate+a.txRate); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.peer.RxBytes+b.peer.TxBytes, a.peer.RxBytes+a.peer.TxBytes)
+	})
+
+	t.lastSampleTime = now
+	t.lastRxBytes = rxBytes
+	t.lastTxBytes = txBytes
+	t.rates = rates
+	t.totalRxRate = totalRxRate
+	t.totalTxRate = totalTxRate
+
+	if !isFirstSample {
+		// Drop expired samples.
+		cutoff := now.Add(-throughputHistoryWindow)
+		firstKept, _ := slices.BinarySearchFunc(t.history, cutoff, func(s rateSample, cutoff time.Time) int {
+			return s.time.Compare(cutoff)
+		})
+		t.history = append(t.history[firstKept:], rateSample{
+			time:   now,
+			rxRate: totalRxRate,
+			txRate: totalTxRate,
+		})
+	}
+}
+
+// Get the received and sent rates from the history as separate series, oldest first.
+func (t *bandwidthTracker) historySeries() (rx []float64, tx []float64) {
+	rx = make([]float64, len(t.history))
+	tx = make([]float64, len(t.history))
+	for i, sample := range t.history {
+		rx[i] = sample.rxRate
+		tx[i] = sample.txRate
+	}
+	return rx, tx
+}
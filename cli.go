@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/neuralinkcorp/tsui/config"
+	"github.com/neuralinkcorp/tsui/libts"
+	"github.com/neuralinkcorp/tsui/ui"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+const cliUsage = `Usage: tsui [--dry-run] [command] [args]
+
+Run without a command to start the interactive interface. With --dry-run, changes are
+printed as the equivalent tailscale command and LocalAPI request instead of being made.
+--dry-run can go anywhere on the command line, including after the command's arguments.
+
+Commands:
+  status [--json]                 Show the connection status and settings
+  up                              Connect to Tailscale
+  down                            Disconnect from Tailscale
+  exit-node list [--json]         List available exit nodes
+  exit-node set NAME              Use an exit node, by name or IP
+  exit-node clear                 Stop using an exit node
+  settings list [--json]          List settings and their current values
+  settings set NAME VALUE         Change a setting
+  watch [--json] [--interval D]   Print a line whenever the state changes
+  record [flags] FILE             Log bandwidth samples to a file
+  help                            Show this message
+`
+
+// Error for invalid command line usage. The usage text is printed along with it.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// Run a command line subcommand. Returns an error to be displayed to the user.
+func runCommand(name string, args []string, cfg config.Config) error {
+	switch name {
+	case "status":
+		return runStatusCommand(args)
+	case "up":
+		return runUpDownCommand(args, true)
+	case "down":
+		return runUpDownCommand(args, false)
+	case "exit-node":
+		return runExitNodeCommand(args)
+	case "settings":
+		return runSettingsCommand(args)
+	case "watch":
+		return runWatch(args, cfg.TickInterval)
+	case "record":
+		return runRecord(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return nil
+	}
+
+	return usageError(fmt.Sprintf("unknown command %q", name))
+}
+
+// Remove every --dry-run flag from args, wherever it is, and report whether there was one.
+// It's accepted anywhere so that adding it to the end of a command always makes it safe.
+func extractDryRunFlag(args []string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	dryRun := false
+	for _, arg := range args {
+		if arg == "--dry-run" {
+			dryRun = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, dryRun
+}
+
+// Print what a change would have done in dry-run mode.
+func printDryRun(w io.Writer, dryRun *libts.DryRunError) {
+	fmt.Fprintln(w, "Dry run: nothing was changed.")
+	fmt.Fprintln(w, "Equivalent command:", dryRun.Command)
+	fmt.Fprintln(w, "LocalAPI request:", dryRun.Request)
+	if dryRun.Body != "" {
+		fmt.Fprintln(w, dryRun.Body)
+	}
+}
+
+// Parse flags for a subcommand that only takes an optional --json flag.
+// Returns the remaining positional arguments.
+func parseJSONFlag(name string, args []string) (jsonOutput bool, rest []string, err error) {
+	flags := flag.NewFlagSet("tsui "+name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.BoolVar(&jsonOutput, "json", false, "print JSON")
+
+	err = flags.Parse(args)
+	if err != nil {
+		return false, nil, usageError(err.Error())
+	}
+	return jsonOutput, flags.Args(), nil
+}
+
+// Print a value as indented JSON to stdout.
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// Fetch the state, failing if Tailscale isn't running for commands that need a connection.
+func getRunningState() (libts.State, error) {
+	state, err := libts.GetState(ctx)
+	if err != nil {
+		return state, err
+	}
+	if state.BackendState != ipn.Running.String() {
+		return state, fmt.Errorf("tailscale is not connected (state: %s)", state.BackendState)
+	}
+	return state, nil
+}
+
+// JSON shape of an exit node.
+type cliExitNode struct {
+	Name    string   `json:"name"`
+	DNSName string   `json:"dnsName"`
+	ID      string   `json:"id"`
+	IPs     []string `json:"ips"`
+	Online  bool     `json:"online"`
+	Active  bool     `json:"active"`
+}
+
+func newCLIExitNode(state *libts.State, peer *ipnstate.PeerStatus) cliExitNode {
+	ips := make([]string, len(peer.TailscaleIPs))
+	for i, ip := range peer.TailscaleIPs {
+		ips[i] = ip.String()
+	}
+
+	return cliExitNode{
+		Name:    libts.PeerName(peer),
+		DNSName: strings.TrimSuffix(peer.DNSName, "."),
+		ID:      string(peer.ID),
+		IPs:     ips,
+		Online:  peer.Online,
+		Active:  state.CurrentExitNode != nil && *state.CurrentExitNode == peer.ID,
+	}
+}
+
+// JSON shape of `tsui status --json`.
+type cliStatus struct {
+	BackendState     string            `json:"backendState"`
+	TailscaleVersion string            `json:"tailscaleVersion"`
+	User             string            `json:"user,omitempty"`
+	Name             string            `json:"name,omitempty"`
+	DNSName          string            `json:"dnsName,omitempty"`
+	IPs              []string          `json:"ips,omitempty"`
+	KeyExpiry        *time.Time        `json:"keyExpiry,omitempty"`
+	ExitNode         *cliExitNode      `json:"exitNode"`
+	Settings         map[string]string `json:"settings,omitempty"`
+	RxBytes          int64             `json:"rxBytes"`
+	TxBytes          int64             `json:"txBytes"`
+	Unavailable      map[string]string `json:"unavailable,omitempty"`
+}
+
+func runStatusCommand(args []string) error {
+	jsonOutput, rest, err := parseJSONFlag("status", args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return usageError("status takes no arguments")
+	}
+
+	state, err := libts.GetState(ctx)
+	if err != nil {
+		return err
+	}
+
+	status := cliStatus{
+		BackendState:     state.BackendState,
+		TailscaleVersion: state.TSVersion,
+		RxBytes:          state.RxBytes,
+		TxBytes:          state.TxBytes,
+	}
+	if state.User != nil {
+		status.User = state.User.LoginName
+	}
+	if state.Self != nil && state.BackendState == ipn.Running.String() {
+		status.Name = libts.PeerName(state.Self)
+		status.DNSName = strings.TrimSuffix(state.Self.DNSName, ".")
+		status.KeyExpiry = state.Self.KeyExpiry
+		for _, ip := range state.Self.TailscaleIPs {
+			status.IPs = append(status.IPs, ip.String())
+		}
+	}
+	for _, peer := range state.SortedExitNodes {
+		if state.CurrentExitNode != nil && peer.ID == *state.CurrentExitNode {
+			exitNode := newCLIExitNode(&state, peer)
+			status.ExitNode = &exitNode
+		}
+	}
+	if state.Prefs != nil {
+		status.Settings = make(map[string]string)
+		for _, s := range allSettings {
+			if s.isSupported() {
+				status.Settings[s.name] = s.get(state.Prefs)
+			}
+		}
+	}
+
+	for section, err := range state.Unavailable {
+		if status.Unavailable == nil {
+			status.Unavailable = make(map[string]string)
+		}
+		status.Unavailable[section] = err.Error()
+	}
+
+	if jsonOutput {
+		return printJSON(status)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Status:\t%s\n", status.BackendState)
+	if status.User != "" {
+		fmt.Fprintf(w, "User:\t%s\n", status.User)
+	}
+	if status.Name != "" {
+		fmt.Fprintf(w, "Name:\t%s (%s)\n", status.Name, status.DNSName)
+		fmt.Fprintf(w, "IPs:\t%s\n", strings.Join(status.IPs, ", "))
+	}
+	if status.KeyExpiry != nil {
+		fmt.Fprintf(w, "Key Expiry:\t%s\n", ui.FormatDuration(time.Until(*status.KeyExpiry)))
+	}
+	if status.ExitNode != nil {
+		fmt.Fprintf(w, "Exit Node:\t%s\n", status.ExitNode.Name)
+	} else {
+		fmt.Fprintf(w, "Exit Node:\tNone\n")
+	}
+	fmt.Fprintf(w, "Traffic:\t▼ %s | %s ▲\n", ui.FormatBytes(status.RxBytes), ui.FormatBytes(status.TxBytes))
+	if err, ok := status.Unavailable[libts.SectionLock]; ok {
+		fmt.Fprintf(w, "Tailnet Lock:\tUnknown (%s)\n", err)
+	}
+	return w.Flush()
+}
+
+func runUpDownCommand(args []string, up bool) error {
+	if len(args) > 0 {
+		return usageError("up and down take no arguments")
+	}
+
+	if up {
+		err := libts.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Tailscale is up.")
+	} else {
+		err := libts.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Tailscale is down.")
+	}
+	return nil
+}
+
+// Find an exit node by its PeerName, DNS name or any of its IPs.
+func findExitNode(state *libts.State, query string) (*ipnstate.PeerStatus, error) {
+	for _, peer := range state.SortedExitNodes {
+		if libts.PeerName(peer) == query || strings.TrimSuffix(peer.DNSName, ".") == strings.TrimSuffix(query, ".") {
+			return peer, nil
+		}
+		for _, ip := range peer.TailscaleIPs {
+			if ip.String() == query {
+				return peer, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no exit node named %q; run `tsui exit-node list` to see available exit nodes", query)
+}
+
+func runExitNodeCommand(args []string) error {
+	if len(args) == 0 {
+		return usageError("exit-node requires a subcommand: list, set or clear")
+	}
+
+	switch args[0] {
+	case "list":
+		jsonOutput, rest, err := parseJSONFlag("exit-node list", args[1:])
+		if err != nil {
+			return err
+		}
+		if len(rest) > 0 {
+			return usageError("exit-node list takes no arguments")
+		}
+
+		state, err := getRunningState()
+		if err != nil {
+			return err
+		}
+
+		exitNodes := make([]cliExitNode, len(state.SortedExitNodes))
+		for i, peer := range state.SortedExitNodes {
+			exitNodes[i] = newCLIExitNode(&state, peer)
+		}
+
+		if jsonOutput {
+			return printJSON(exitNodes)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, exitNode := range exitNodes {
+			marker := " "
+			if exitNode.Active {
+				marker = "*"
+			}
+			online := "online"
+			if !exitNode.Online {
+				online = "offline"
+			}
+			fmt.Fprintf(w, "%s %s\t%s\t%s\n", marker, exitNode.Name, strings.Join(exitNode.IPs, ", "), online)
+		}
+		return w.Flush()
+
+	case "set":
+		if len(args) != 2 {
+			return usageError("exit-node set requires exactly one exit node name")
+		}
+
+		state, err := getRunningState()
+		if err != nil {
+			return err
+		}
+		peer, err := findExitNode(&state, args[1])
+		if err != nil {
+			return err
+		}
+
+		err = libts.SetExitNode(ctx, peer)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Using exit node %s.\n", libts.PeerName(peer))
+		return nil
+
+	case "clear":
+		if len(args) != 1 {
+			return usageError("exit-node clear takes no arguments")
+		}
+
+		err := libts.SetExitNode(ctx, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Stopped using an exit node.")
+		return nil
+	}
+
+	return usageError(fmt.Sprintf("unknown exit-node subcommand %q", args[0]))
+}
+
+// JSON shape of a setting in `tsui settings list --json`.
+type cliSetting struct {
+	Name    string   `json:"name"`
+	Label   string   `json:"label"`
+	Value   string   `json:"value"`
+	Options []string `json:"options"`
+}
+
+func runSettingsCommand(args []string) error {
+	if len(args) == 0 {
+		return usageError("settings requires a subcommand: list or set")
+	}
+
+	switch args[0] {
+	case "list":
+		jsonOutput, rest, err := parseJSONFlag("settings list", args[1:])
+		if err != nil {
+			return err
+		}
+		if len(rest) > 0 {
+			return usageError("settings list takes no arguments")
+		}
+
+		prefs, err := libts.Prefs(ctx)
+		if err != nil {
+			return err
+		}
+
+		var settings []cliSetting
+		for _, s := range allSettings {
+			if s.isSupported() {
+				settings = append(settings, cliSetting{
+					Name:    s.name,
+					Label:   s.label,
+					Value:   s.get(prefs),
+					Options: s.options,
+				})
+			}
+		}
+
+		if jsonOutput {
+			return printJSON(settings)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, s := range settings {
+			value, options := s.Value, strings.Join(s.Options, ", ")
+			if s.Options == nil {
+				value, options = strconv.Quote(value), "any text"
+			}
+			fmt.Fprintf(w, "%s\t%s\t(%s)\n", s.Name, value, options)
+		}
+		return w.Flush()
+
+	case "set":
+		if len(args) != 3 {
+			return usageError("settings set requires a setting name and a value")
+		}
+
+		s, err := findSetting(args[1])
+		if err != nil {
+			return err
+		}
+		value, err := s.parseValue(args[2])
+		if err != nil {
+			return err
+		}
+
+		prefs, err := libts.Prefs(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = libts.EditPrefs(ctx, s.edit(prefs, value))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", s.label, s.display(value))
+		return nil
+	}
+
+	return usageError(fmt.Sprintf("unknown settings subcommand %q", args[0]))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A tailscale CLI command that does the same thing as a change made through tsui.
+type commandEntry struct {
+	time time.Time
+	// Description of the change, like "Use Subnet Routes: No".
+	label string
+	// Command, like "tailscale set --accept-routes=false".
+	command string
+}
+
+// Message reporting that a change was made that isn't a preference edit, like connecting,
+// along with its equivalent command. Handled like next afterwards.
+type commandRanMsg struct {
+	label   string
+	command string
+	next    tea.Msg
+}
+
+// Message reporting that the command log was exported to a file.
+type commandsExportedMsg string
+
+// Add a command to the log.
+func (m *model) recordCommand(label string, command string) {
+	if command == "" {
+		return
+	}
+	m.commands = append(m.commands, commandEntry{time.Now(), label, command})
+}
+
+// Format the command log as a shell script that repeats the session's changes in order.
+func commandScript(entries []commandEntry) string {
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	script.WriteString("# Changes made in tsui, as tailscale commands.\n")
+	script.WriteString("set -e\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&script, "\n# %s (%s)\n", entry.label, entry.time.Format(time.DateTime))
+		script.WriteString(entry.command + "\n")
+	}
+	return script.String()
+}
+
+// Create a command that writes the command log to an executable shell script in the current
+// directory.
+func exportCommands(entries []commandEntry) tea.Cmd {
+	return func() tea.Msg {
+		path := fmt.Sprintf("tsui-commands-%s.sh", time.Now().Format("20060102-150405"))
+		err := os.WriteFile(path, []byte(commandScript(entries)), 0o755)
+		if err != nil {
+			return errorMsg(err)
+		}
+		return commandsExportedMsg(path)
+	}
+}
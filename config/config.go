@@ -0,0 +1,409 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Names of the main menu items that can be opened on startup.
+var MenuNames = []string{"this-device", "exit-nodes", "settings", "presets", "whois", "dns", "bandwidth", "config", "preferences", "history", "commands"}
+
+// Peer latency measurement settings.
+type Ping struct {
+	// Whether to measure latency to exit nodes at all.
+	Enabled bool `toml:"enabled"`
+	// Time between rounds of pings.
+	Interval time.Duration `toml:"interval"`
+	// How long to wait for each peer to respond.
+	Timeout time.Duration `toml:"timeout"`
+}
+
+// How long messages stay in the status bar.
+type Messages struct {
+	ErrorLifetime   time.Duration `toml:"error_lifetime"`
+	SuccessLifetime time.Duration `toml:"success_lifetime"`
+	TipLifetime     time.Duration `toml:"tip_lifetime"`
+}
+
+// Interface colors, as ANSI color numbers ("0" to "255") or hex codes ("#ff00ff"). Empty
+// colors are taken from the theme.
+type Colors struct {
+	Primary   string `toml:"primary"`
+	Secondary string `toml:"secondary"`
+	Red       string `toml:"red"`
+	Blue      string `toml:"blue"`
+	Green     string `toml:"green"`
+	Yellow    string `toml:"yellow"`
+	White     string `toml:"white"`
+	DarkGray  string `toml:"dark_gray"`
+	Black     string `toml:"black"`
+	// Color of de-emphasized text. Themes without one use the terminal's faint attribute.
+	Muted string `toml:"muted"`
+}
+
+// A user-defined theme: a built-in theme with some of its colors replaced.
+type Theme struct {
+	// Name of the built-in theme to start from. Defaults to "dark".
+	Base string `toml:"base"`
+	Colors
+}
+
+// Which actions ask for confirmation first.
+type Confirm struct {
+	// Disconnecting from Tailscale, from the menu or with the hotkey.
+	Disconnect bool `toml:"disconnect"`
+	// Logging out.
+	LogOut bool `toml:"log_out"`
+	// Risky setting changes, like turning off DNS settings or changing the netfilter mode.
+	Settings bool `toml:"settings"`
+}
+
+// A named set of preferences that can be applied all at once. Missing fields are left
+// unchanged when the preset is applied.
+type Preset struct {
+	AllowIncoming      *bool `toml:"allow_incoming"`
+	UseSubnetRoutes    *bool `toml:"use_subnet_routes"`
+	UseDNSSettings     *bool `toml:"use_dns_settings"`
+	LocalNetworkAccess *bool `toml:"local_network_access"`
+	// Name, DNS name or IP of the exit node, or empty for none.
+	ExitNode *string `toml:"exit_node"`
+	// Routes to advertise in CIDR notation, including "0.0.0.0/0" and "::/0" to advertise
+	// an exit node.
+	AdvertiseRoutes *[]string `toml:"advertise_routes"`
+	// One of "on", "nodivert" or "off". Only applies on Linux.
+	NetfilterMode *string `toml:"netfilter_mode"`
+}
+
+// Netfilter modes accepted in presets.
+var NetfilterModes = []string{"on", "nodivert", "off"}
+
+// Check that the preset's values are valid, using prefix for the keys in error messages.
+func (preset *Preset) validate(prefix string) error {
+	if preset.AdvertiseRoutes != nil {
+		for _, route := range *preset.AdvertiseRoutes {
+			_, err := netip.ParsePrefix(route)
+			if err != nil {
+				return fmt.Errorf("%s.advertise_routes: %q is not a route like \"10.0.0.0/24\"", prefix, route)
+			}
+		}
+	}
+	if preset.NetfilterMode != nil && !slices.Contains(NetfilterModes, *preset.NetfilterMode) {
+		return fmt.Errorf("%s.netfilter_mode: %q is not one of: %s", prefix, *preset.NetfilterMode, strings.Join(NetfilterModes, ", "))
+	}
+	return nil
+}
+
+var presetNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+( [A-Za-z0-9_-]+)*$`)
+
+// Returns an error if name can't be used for a preset.
+func ValidatePresetName(name string) error {
+	if !presetNameRegexp.MatchString(name) {
+		return fmt.Errorf("preset name %q must be letters, numbers, dashes, underscores and single spaces", name)
+	}
+	return nil
+}
+
+// Add a preset to the end of the config file at path, creating the file if needed. The
+// rest of the file, including comments, is left alone.
+func AppendPreset(path string, name string, preset Preset) error {
+	err := ValidatePresetName(name)
+	if err != nil {
+		return err
+	}
+
+	var table strings.Builder
+	table.WriteString("\n[presets.")
+	if strings.Contains(name, " ") {
+		table.WriteString(strconv.Quote(name))
+	} else {
+		table.WriteString(name)
+	}
+	table.WriteString("]\n")
+	err = toml.NewEncoder(&table).Encode(preset)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(table.String())
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Column widths of the menus.
+type Layout struct {
+	AppmenuWidth int `toml:"appmenu_width"`
+	SubmenuWidth int `toml:"submenu_width"`
+}
+
+// Complete tsui configuration. Fields missing from the file keep their defaults.
+type Config struct {
+	// Rate at which to poll Tailscale for status updates.
+	TickInterval time.Duration `toml:"tick_interval"`
+	// How long to wait for tailscaled to answer a request before giving up. Zero means no
+	// limit.
+	RequestTimeout time.Duration `toml:"request_timeout"`
+	// Main menu item to open on startup, one of MenuNames, or empty for none.
+	DefaultMenu string `toml:"default_menu"`
+	// Name of a built-in theme or one from Themes, or "auto" to pick "dark" or "light"
+	// from the terminal's background. Ignored if NO_COLOR is set, which always means
+	// "none".
+	Theme string `toml:"theme"`
+
+	Ping     Ping     `toml:"ping"`
+	Messages Messages `toml:"messages"`
+	Colors   Colors   `toml:"colors"`
+	Layout   Layout   `toml:"layout"`
+	Confirm  Confirm  `toml:"confirm"`
+
+	// User-defined themes, by name.
+	Themes map[string]Theme `toml:"themes"`
+	// Preference presets, by name.
+	Presets map[string]Preset `toml:"presets"`
+
+	// Key overrides, from action names like "quit" to lists of keys like ["ctrl+q"].
+	// Each override replaces all of the action's default keys.
+	Keys map[string][]string `toml:"keys"`
+}
+
+// The configuration used when there's no config file.
+func Default() Config {
+	return Config{
+		TickInterval:   3 * time.Second,
+		RequestTimeout: 10 * time.Second,
+		Theme:          "auto",
+		Ping: Ping{
+			Enabled:  true,
+			Interval: 6 * time.Second,
+			Timeout:  1 * time.Second,
+		},
+		Messages: Messages{
+			ErrorLifetime:   6 * time.Second,
+			SuccessLifetime: 3 * time.Second,
+			TipLifetime:     3 * time.Second,
+		},
+		Layout: Layout{
+			AppmenuWidth: 35,
+			SubmenuWidth: 45,
+		},
+		Confirm: Confirm{
+			Disconnect: true,
+			LogOut:     true,
+			Settings:   true,
+		},
+	}
+}
+
+// Location of the config file: $XDG_CONFIG_HOME/tsui/config.toml, falling back to
+// ~/.config/tsui/config.toml.
+func Path() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "tsui", "config.toml"), nil
+}
+
+// Load and validate the config file at path. If it doesn't exist, returns the defaults
+// and found = false.
+func Load(path string) (cfg Config, found bool, err error) {
+	cfg = Default()
+
+	meta, err := toml.DecodeFile(path, &cfg)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Default(), false, nil
+	}
+	if err != nil {
+		return cfg, true, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return cfg, true, fmt.Errorf("config file %s: unknown keys: %s", path, strings.Join(keys, ", "))
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return cfg, true, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return cfg, true, nil
+}
+
+var hexColorRegexp = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Returns an error if color isn't empty, an ANSI color number or a hex code.
+func validateColor(key string, color string) error {
+	if color == "" || hexColorRegexp.MatchString(color) {
+		return nil
+	}
+	if n, err := strconv.Atoi(color); err == nil && n >= 0 && n <= 255 {
+		return nil
+	}
+	return fmt.Errorf("%s: %q is not a color number from 0 to 255 or a hex code like \"#ff00ff\"", key, color)
+}
+
+// Check that every color is valid, using prefix for the keys in error messages.
+func (colors *Colors) validate(prefix string) error {
+	for _, entry := range colors.entries(prefix) {
+		err := validateColor(entry.Key, entry.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List every color, with keys under prefix.
+func (colors *Colors) entries(prefix string) []Entry {
+	return []Entry{
+		{prefix + ".primary", colors.Primary},
+		{prefix + ".secondary", colors.Secondary},
+		{prefix + ".red", colors.Red},
+		{prefix + ".blue", colors.Blue},
+		{prefix + ".green", colors.Green},
+		{prefix + ".yellow", colors.Yellow},
+		{prefix + ".white", colors.White},
+		{prefix + ".dark_gray", colors.DarkGray},
+		{prefix + ".black", colors.Black},
+		{prefix + ".muted", colors.Muted},
+	}
+}
+
+// Check that all values are in range.
+func (cfg *Config) validate() error {
+	durations := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"tick_interval", cfg.TickInterval},
+		{"ping.interval", cfg.Ping.Interval},
+		{"ping.timeout", cfg.Ping.Timeout},
+		{"messages.error_lifetime", cfg.Messages.ErrorLifetime},
+		{"messages.success_lifetime", cfg.Messages.SuccessLifetime},
+		{"messages.tip_lifetime", cfg.Messages.TipLifetime},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be a positive duration like \"3s\"", d.key)
+		}
+	}
+
+	if cfg.RequestTimeout < 0 {
+		return errors.New("request_timeout must be a duration like \"10s\", or \"0s\" for no limit")
+	}
+
+	if cfg.TickInterval < 500*time.Millisecond {
+		return errors.New("tick_interval must be at least 500ms")
+	}
+
+	if cfg.DefaultMenu != "" && !slices.Contains(MenuNames, cfg.DefaultMenu) {
+		return fmt.Errorf("default_menu: %q is not one of: %s", cfg.DefaultMenu, strings.Join(MenuNames, ", "))
+	}
+
+	if cfg.Theme == "" {
+		return errors.New("theme must not be empty")
+	}
+
+	err := cfg.Colors.validate("colors")
+	if err != nil {
+		return err
+	}
+	for name, theme := range cfg.Themes {
+		err := theme.Colors.validate("themes." + name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, preset := range cfg.Presets {
+		err := ValidatePresetName(name)
+		if err != nil {
+			return fmt.Errorf("presets: %w", err)
+		}
+		err = preset.validate("presets." + name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Layout.AppmenuWidth < 20 {
+		return errors.New("layout.appmenu_width must be at least 20")
+	}
+	if cfg.Layout.SubmenuWidth < 30 {
+		return errors.New("layout.submenu_width must be at least 30")
+	}
+
+	return nil
+}
+
+// A single effective setting, for display.
+type Entry struct {
+	// Dotted TOML key, such as "ping.interval".
+	Key string
+	// Value formatted as it would be written in the file.
+	Value string
+}
+
+// List every setting and its effective value, in file order.
+func (cfg *Config) Entries() []Entry {
+	defaultMenu := cfg.DefaultMenu
+	if defaultMenu == "" {
+		defaultMenu = "(none)"
+	}
+
+	entries := []Entry{
+		{"tick_interval", cfg.TickInterval.String()},
+		{"request_timeout", cfg.RequestTimeout.String()},
+		{"default_menu", defaultMenu},
+		{"theme", cfg.Theme},
+		{"ping.enabled", strconv.FormatBool(cfg.Ping.Enabled)},
+		{"ping.interval", cfg.Ping.Interval.String()},
+		{"ping.timeout", cfg.Ping.Timeout.String()},
+		{"messages.error_lifetime", cfg.Messages.ErrorLifetime.String()},
+		{"messages.success_lifetime", cfg.Messages.SuccessLifetime.String()},
+		{"messages.tip_lifetime", cfg.Messages.TipLifetime.String()},
+	}
+	for _, entry := range cfg.Colors.entries("colors") {
+		if entry.Value == "" {
+			entry.Value = "(theme)"
+		}
+		entries = append(entries, entry)
+	}
+	entries = append(entries,
+		Entry{"layout.appmenu_width", strconv.Itoa(cfg.Layout.AppmenuWidth)},
+		Entry{"layout.submenu_width", strconv.Itoa(cfg.Layout.SubmenuWidth)},
+		Entry{"confirm.disconnect", strconv.FormatBool(cfg.Confirm.Disconnect)},
+		Entry{"confirm.log_out", strconv.FormatBool(cfg.Confirm.LogOut)},
+		Entry{"confirm.settings", strconv.FormatBool(cfg.Confirm.Settings)},
+	)
+	return entries
+}
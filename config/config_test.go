@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppendPresetRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tsui", "config.toml")
+
+	yes, no := true, false
+	exitNode := ""
+	routes := []string{"10.0.0.0/24", "::/0"}
+	presets := map[string]Preset{
+		// Only some fields set, so the rest must be left out of the file.
+		"home": {
+			AllowIncoming: &yes,
+			ExitNode:      &exitNode,
+		},
+		"coffee shop": {
+			AllowIncoming:      &no,
+			UseSubnetRoutes:    &no,
+			UseDNSSettings:     &yes,
+			LocalNetworkAccess: &no,
+			AdvertiseRoutes:    &routes,
+		},
+	}
+
+	err := AppendPreset(path, "home", presets["home"])
+	if err != nil {
+		t.Fatalf("AppendPreset(home): %v", err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"use_subnet_routes", "use_dns_settings", "local_network_access", "advertise_routes", "netfilter_mode"} {
+		if strings.Contains(string(contents), key) {
+			t.Errorf("config file has %s, which the preset leaves out:\n%s", key, contents)
+		}
+	}
+
+	// Appending to a file that already has a preset, with a name that needs quoting.
+	err = AppendPreset(path, "coffee shop", presets["coffee shop"])
+	if err != nil {
+		t.Fatalf("AppendPreset(coffee shop): %v", err)
+	}
+
+	cfg, found, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !found {
+		t.Fatalf("Load didn't find %s", path)
+	}
+	if !reflect.DeepEqual(cfg.Presets, presets) {
+		t.Errorf("loaded presets = %+v, want %+v", cfg.Presets, presets)
+	}
+}
+
+func TestAppendPresetRejectsBadName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+
+	err := AppendPreset(path, "home]\n[evil", Preset{})
+	if err == nil {
+		t.Fatalf("AppendPreset with a bad name succeeded")
+	}
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("AppendPreset with a bad name created the config file")
+	}
+}
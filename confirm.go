@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/neuralinkcorp/tsui/clipboard"
+	"github.com/neuralinkcorp/tsui/libts"
+	"github.com/neuralinkcorp/tsui/ui"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+)
+
+// If tsui is running in an SSH session that comes in over the tailnet, get the address
+// of the SSH client. Uses the SSH_CONNECTION variable set by OpenSSH and Tailscale SSH.
+func sshOverTailnet(self *ipnstate.PeerStatus) (netip.Addr, bool) {
+	// Format: "client_ip client_port server_ip server_port".
+	fields := strings.Fields(os.Getenv("SSH_CONNECTION"))
+	if len(fields) != 4 {
+		return netip.Addr{}, false
+	}
+
+	clientIP, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	serverIP, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	clientIP, serverIP = clientIP.Unmap(), serverIP.Unmap()
+
+	if self != nil && slices.Contains(self.TailscaleIPs, serverIP) {
+		return clientIP, true
+	}
+	return clientIP, tsaddr.IsTailscaleIP(clientIP)
+}
+
+// Make a confirmation dialog, or return nil if enabled is false so the action runs
+// immediately. Adds a warning if the action could cut off the user's SSH session.
+func (m *model) makeConfirm(enabled bool, title string, body string, confirmLabel string) *ui.Confirm {
+	if !enabled {
+		return nil
+	}
+
+	confirm := &ui.Confirm{
+		Title:        title,
+		Body:         body,
+		ConfirmLabel: confirmLabel,
+	}
+	if clientIP, ok := sshOverTailnet(m.state.Self); ok {
+		confirm.Warning = fmt.Sprintf("You're connected over SSH through Tailscale (from %s). This may cut off your session.", clientIP)
+	}
+	return confirm
+}
+
+// Make the confirmation dialog for disconnecting from Tailscale, or nil if disabled.
+func (m *model) disconnectConfirm() *ui.Confirm {
+	return m.makeConfirm(m.config.Confirm.Disconnect,
+		"Disconnect from Tailscale?",
+		"This device will leave the tailnet until you connect again.",
+		"Disconnect",
+	)
+}
+
+// Get a function that makes the confirmation dialog for risky changes to a setting.
+func (m *model) settingConfirm(s *setting) func(value string) *ui.Confirm {
+	if s.risk == nil {
+		return nil
+	}
+	return func(value string) *ui.Confirm {
+		risk := s.risk(value)
+		if risk == "" {
+			return nil
+		}
+		return m.makeConfirm(m.config.Confirm.Settings,
+			fmt.Sprintf("Change %s to %s?", s.label, value),
+			risk,
+			"Change",
+		)
+	}
+}
+
+// Make the dialog showing what a change would have done in dry-run mode, with a button to
+// copy the request body.
+func dryRunDialog(dryRun *libts.DryRunError) *ui.Confirm {
+	body := "Equivalent command:\n" + dryRun.Command + "\n\nLocalAPI request:\n" + dryRun.Request
+	if dryRun.Body != "" {
+		body += "\n" + dryRun.Body
+	}
+
+	dialog := &ui.Confirm{
+		Title:        "Dry run: nothing was changed",
+		Body:         body,
+		ConfirmLabel: "Copy Command",
+		CancelLabel:  "Close",
+		OnConfirm: func() tea.Msg {
+			err := clipboard.WriteString(dryRun.Command)
+			if err != nil {
+				return errorMsg(err)
+			}
+			return successMsg("Copied command to clipboard.")
+		},
+	}
+	return dialog
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/neuralinkcorp/tsui/libts"
+	"tailscale.com/ipn"
+)
+
+// Number of preference changes kept for undo.
+const maxHistoryEntries = 50
+
+// A preference change made through tsui, with the edit that undoes it.
+type prefsChange struct {
+	time time.Time
+	// Description of the change, like "Use Subnet Routes: No".
+	label string
+	// Edit that made the change.
+	edit *ipn.MaskedPrefs
+	// Edit that restores the previous values of exactly the fields that were changed.
+	revert *ipn.MaskedPrefs
+	// Whether the change has been undone.
+	undone bool
+	// Whether an undo of the change has been sent but hasn't finished yet.
+	undoing bool
+}
+
+// Message reporting a successfully applied preference change, to be added to the history.
+type prefsEditedMsg prefsChange
+
+// Message asking to undo the most recent change that hasn't been undone yet.
+type undoMsg struct{}
+
+// Message reporting that a change in the history was undone.
+type prefsUndoneMsg *prefsChange
+
+// Message reporting that undoing a change in the history failed.
+type undoFailedMsg struct {
+	change *prefsChange
+	err    error
+}
+
+// Record a change in the history, dropping the oldest entries past the limit.
+func (m *model) recordChange(change *prefsChange) {
+	m.history = append(m.history, change)
+	if len(m.history) > maxHistoryEntries {
+		m.history = m.history[len(m.history)-maxHistoryEntries:]
+	}
+}
+
+// Get the most recent change that hasn't been undone and isn't being undone, or nil if
+// there's none.
+func (m *model) lastUndoableChange() *prefsChange {
+	for i := len(m.history) - 1; i >= 0; i-- {
+		if !m.history[i].undone && !m.history[i].undoing {
+			return m.history[i]
+		}
+	}
+	return nil
+}
+
+// Create a command that reverts the most recent change that hasn't been undone yet. The
+// change is marked as being undone right away, so undoing again before it finishes
+// reverts the next older change. Must only be called from Update.
+func (m *model) undo() tea.Cmd {
+	change := m.lastUndoableChange()
+	if change == nil {
+		return func() tea.Msg {
+			return tipMsg("There's nothing to undo.")
+		}
+	}
+	change.undoing = true
+	m.updateMenus()
+
+	revert := change.revert
+	return func() tea.Msg {
+		err := libts.EditPrefs(ctx, revert)
+		if err != nil {
+			return undoFailedMsg{change: change, err: err}
+		}
+		return prefsUndoneMsg(change)
+	}
+}
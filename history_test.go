@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+// Make a model whose history has changes with the given labels, oldest first.
+func modelWithHistory(labels ...string) *model {
+	m := &model{}
+	for _, label := range labels {
+		m.recordChange(&prefsChange{label: label, revert: &ipn.MaskedPrefs{ShieldsUpSet: true}})
+	}
+	return m
+}
+
+func TestRecordChangeKeepsNewest(t *testing.T) {
+	m := &model{}
+	for i := range maxHistoryEntries + 10 {
+		m.recordChange(&prefsChange{label: fmt.Sprint("change ", i)})
+	}
+
+	if len(m.history) != maxHistoryEntries {
+		t.Fatalf("history has %d entries, want %d", len(m.history), maxHistoryEntries)
+	}
+	if got, want := m.history[0].label, "change 10"; got != want {
+		t.Errorf("oldest entry = %q, want %q", got, want)
+	}
+	if got, want := m.history[len(m.history)-1].label, fmt.Sprint("change ", maxHistoryEntries+9); got != want {
+		t.Errorf("newest entry = %q, want %q", got, want)
+	}
+}
+
+func TestLastUndoableChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		undone  []bool
+		undoing []bool
+		want    string
+	}{
+		{"empty", nil, nil, ""},
+		{"newest", []bool{false, false, false}, []bool{false, false, false}, "c"},
+		{"skips undone", []bool{false, false, true}, []bool{false, false, false}, "b"},
+		{"skips undoing", []bool{false, false, false}, []bool{false, true, true}, "a"},
+		{"all undone", []bool{true, true, false}, []bool{false, false, true}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := modelWithHistory([]string{"a", "b", "c"}[:len(test.undone)]...)
+			for i, change := range m.history {
+				change.undone = test.undone[i]
+				change.undoing = test.undoing[i]
+			}
+
+			var got string
+			if change := m.lastUndoableChange(); change != nil {
+				got = change.label
+			}
+			if got != test.want {
+				t.Errorf("lastUndoableChange = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUndoMarksChangeUntilItFinishes(t *testing.T) {
+	m := modelWithHistory("a", "b")
+	a, b := m.history[0], m.history[1]
+
+	// Undoing twice before either finishes undoes both changes, newest first.
+	m.undo()
+	if !b.undoing {
+		t.Fatalf("b isn't marked as being undone")
+	}
+	m.undo()
+	if !a.undoing {
+		t.Fatalf("second undo didn't move on to a")
+	}
+	if msg := m.undo()(); msg != tipMsg("There's nothing to undo.") {
+		t.Errorf("third undo = %#v, want the nothing to undo tip", msg)
+	}
+
+	// A failed undo makes the change undoable again.
+	result, _ := m.Update(undoFailedMsg{change: b, err: errors.New("failed")})
+	updated := result.(model)
+	if b.undoing || b.undone {
+		t.Errorf("b after failed undo: undoing = %v, undone = %v, want both false", b.undoing, b.undone)
+	}
+	if change := updated.lastUndoableChange(); change != b {
+		t.Errorf("lastUndoableChange after failed undo = %v, want b", change)
+	}
+
+	// A finished undo is done for good.
+	result, _ = updated.Update(prefsUndoneMsg(a))
+	updated = result.(model)
+	if a.undoing || !a.undone {
+		t.Errorf("a after undo: undoing = %v, undone = %v, want false and true", a.undoing, a.undone)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A change sent to tailscaled that the menus show before it's confirmed.
+type inFlightEdit struct {
+	// Value the item was changed to, like "Yes" or an exit node name.
+	value string
+	// Whether the change succeeded and is just waiting for a state that shows it.
+	done bool
+	// When the change finished. Only states requested after this are sure to show it.
+	finished time.Time
+}
+
+// Message starting a change, unless another change with the same key is still running.
+type inFlightMsg struct {
+	// Identifies what's being changed. The setting name or "exit-node".
+	key string
+	// Value shown while the change is running.
+	value string
+	// Command making the change.
+	cmd tea.Cmd
+}
+
+// Message with the result of an in-flight change.
+type inFlightDoneMsg struct {
+	key      string
+	result   tea.Msg
+	finished time.Time
+}
+
+// Get the in-flight change for key that's still waiting on tailscaled, or nil if there
+// isn't one.
+func (m *model) runningEdit(key string) *inFlightEdit {
+	edit := m.inFlight[key]
+	if edit == nil || edit.done {
+		return nil
+	}
+	return edit
+}
+
+// Start an in-flight change, or ignore it if one with the same key is already running.
+func (m *model) startInFlight(msg inFlightMsg) tea.Cmd {
+	if m.runningEdit(msg.key) != nil {
+		// Put back anything the item changed eagerly.
+		m.updateMenus()
+		return nil
+	}
+
+	m.inFlight[msg.key] = &inFlightEdit{value: msg.value}
+	m.updateMenus()
+	return func() tea.Msg {
+		result := msg.cmd()
+		return inFlightDoneMsg{key: msg.key, result: result, finished: time.Now()}
+	}
+}
+
+// Record the result of an in-flight change. Failed changes are dropped so the menus snap
+// back to the current value. Successful ones keep showing until a state that shows them arrives.
+func (m *model) finishInFlight(msg inFlightDoneMsg) {
+	if _, ok := msg.result.(errorMsg); ok {
+		delete(m.inFlight, msg.key)
+	} else if edit := m.inFlight[msg.key]; edit != nil {
+		edit.done = true
+		edit.finished = msg.finished
+	}
+	m.updateMenus()
+}
+
+// Drop the changes that the state shows. States requested before a change finished may
+// not show it yet, so those changes stay.
+func (m *model) clearFinishedInFlight() {
+	for key, edit := range m.inFlight {
+		if edit.done && edit.finished.Before(m.state.Requested) {
+			delete(m.inFlight, key)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/neuralinkcorp/tsui/ui"
+	"tailscale.com/ipn"
+)
+
+// A named user action that can be bound to keys.
+type action string
+
+const (
+	actionNone             action = ""
+	actionQuit             action = "quit"
+	actionClose            action = "close"
+	actionBack             action = "back"
+	actionUp               action = "up"
+	actionDown             action = "down"
+	actionPageUp           action = "page-up"
+	actionPageDown         action = "page-down"
+	actionHome             action = "home"
+	actionEnd              action = "end"
+	actionOpen             action = "open"
+	actionActivate         action = "activate"
+	actionToggleConnection action = "toggle-connection"
+	actionWhois            action = "whois"
+	actionToggleGraph      action = "toggle-graph"
+	actionHelp             action = "help"
+	actionUndo             action = "undo"
+	actionAbort            action = "abort"
+	actionConfirmYes       action = "confirm-yes"
+	actionConfirmCancel    action = "confirm-cancel"
+	actionConfirmSwitch    action = "confirm-switch"
+	actionConfirmPress     action = "confirm-press"
+	actionInputSubmit      action = "input-submit"
+	actionInputCancel      action = "input-cancel"
+	actionInputDelete      action = "input-delete"
+	actionInputClear       action = "input-clear"
+)
+
+// Which part of the interface has focus, which determines the keys that work.
+type keyContext int
+
+const (
+	// The main menu, with no submenu open.
+	keyContextMainMenu keyContext = iota
+	// An open submenu.
+	keyContextSubmenu
+	// The login, stopped or loading banner shown instead of the menus.
+	keyContextBanner
+	// A text field being edited. Printable keys are typed into the field.
+	keyContextInput
+	// A confirmation dialog.
+	keyContextConfirm
+)
+
+func (c keyContext) String() string {
+	switch c {
+	case keyContextMainMenu:
+		return "Main Menu"
+	case keyContextSubmenu:
+		return "Submenu"
+	case keyContextBanner:
+		return "Login"
+	case keyContextInput:
+		return "Text Field"
+	case keyContextConfirm:
+		return "Confirmation"
+	}
+	return "???"
+}
+
+// Shorthands for the contexts a binding applies to.
+var (
+	inMenus   = []keyContext{keyContextMainMenu, keyContextSubmenu}
+	inAnyPane = []keyContext{keyContextMainMenu, keyContextSubmenu, keyContextBanner}
+	inConfirm = []keyContext{keyContextConfirm}
+	inInput   = []keyContext{keyContextInput}
+)
+
+// Keys bound to an action, using bubbletea's key names like "ctrl+c", "left" or "q".
+type keyBinding struct {
+	action action
+	keys   []string
+	// Short description of what the action does.
+	help string
+	// Where the action can be used. Keys are ignored elsewhere.
+	contexts []keyContext
+}
+
+// Default bindings, in the order they're displayed.
+var defaultKeyBindings = []keyBinding{
+	{actionUp, []string{"up", "k", "w"}, "Move up", inMenus},
+	{actionDown, []string{"down", "j", "s"}, "Move down", inMenus},
+	{actionPageUp, []string{"pgup"}, "Move up a page", inMenus},
+	{actionPageDown, []string{"pgdown"}, "Move down a page", inMenus},
+	{actionHome, []string{"home"}, "Move to the top", inMenus},
+	{actionEnd, []string{"end"}, "Move to the bottom", inMenus},
+	{actionOpen, []string{"right", "l", "d"}, "Open submenu", []keyContext{keyContextMainMenu}},
+	{actionBack, []string{"left", "h", "a"}, "Close submenu", []keyContext{keyContextSubmenu}},
+	{actionActivate, []string{"enter", " "}, "Select item", inMenus},
+	{actionClose, []string{"esc"}, "Close submenu, or quit", inAnyPane},
+	{actionToggleConnection, []string{"."}, "Connect, disconnect or log in", inAnyPane},
+	{actionWhois, []string{"i"}, "Look up a tailnet address", inMenus},
+	{actionToggleGraph, []string{"g"}, "Show or hide throughput graph", inMenus},
+	{actionUndo, []string{"u"}, "Undo the last preference change", inMenus},
+	{actionAbort, []string{"x"}, "Abort a slow request to tailscaled", inAnyPane},
+	{actionConfirmYes, []string{"y"}, "Confirm", inConfirm},
+	{actionConfirmCancel, []string{"n", "esc"}, "Cancel", inConfirm},
+	{actionConfirmSwitch, []string{"left", "right", "tab"}, "Switch button", inConfirm},
+	{actionConfirmPress, []string{"enter"}, "Press the focused button", inConfirm},
+	{actionInputSubmit, []string{"enter"}, "Submit", inInput},
+	{actionInputCancel, []string{"esc"}, "Stop editing", inInput},
+	{actionInputDelete, []string{"backspace"}, "Delete a character", inInput},
+	{actionInputClear, []string{"ctrl+u"}, "Clear the field", inInput},
+	{actionHelp, []string{"?", "f1"}, "Show or hide this help", []keyContext{keyContextMainMenu, keyContextSubmenu, keyContextBanner, keyContextInput, keyContextConfirm}},
+	{actionQuit, []string{"q"}, "Quit", inAnyPane},
+}
+
+// Key that always quits, no matter the configuration, so there's always a way out.
+const emergencyQuitKey = "ctrl+c"
+
+// Mapping between keys and actions.
+type keymap struct {
+	// All bindings, in display order.
+	bindings []keyBinding
+	// Action for each bound key, in each context.
+	actions map[keyContext]map[string]action
+}
+
+// Build the keymap from the defaults and the user's overrides from the config file, where
+// each override replaces all of an action's default keys. Returns an error for unknown
+// actions, unknown key names, and keys bound to more than one action in the same context.
+func newKeymap(overrides map[string][]string) (keymap, error) {
+	k := keymap{
+		bindings: slices.Clone(defaultKeyBindings),
+		actions:  make(map[keyContext]map[string]action),
+	}
+
+	// Sort for deterministic error messages.
+	overrideNames := make([]string, 0, len(overrides))
+	for name := range overrides {
+		overrideNames = append(overrideNames, name)
+	}
+	slices.Sort(overrideNames)
+
+	for _, name := range overrideNames {
+		i := slices.IndexFunc(k.bindings, func(b keyBinding) bool {
+			return string(b.action) == name
+		})
+		if i == -1 {
+			return k, fmt.Errorf("keys: unknown action %q", name)
+		}
+		if len(overrides[name]) == 0 {
+			return k, fmt.Errorf("keys.%s: must have at least one key", name)
+		}
+		k.bindings[i].keys = overrides[name]
+	}
+
+	for _, binding := range k.bindings {
+		for _, key := range binding.keys {
+			if !isKnownKey(key) {
+				return k, fmt.Errorf("keys.%s: %q is not a key name like \"q\", \"ctrl+q\" or \"f1\"", binding.action, key)
+			}
+			if key == emergencyQuitKey {
+				return k, fmt.Errorf("keys.%s: %s is reserved for quitting", binding.action, emergencyQuitKey)
+			}
+			for _, context := range binding.contexts {
+				// Printable keys type into text fields instead, so they only count there
+				// for actions that can be used elsewhere too.
+				if context == keyContextInput && isPrintableKey(key) {
+					if len(binding.contexts) == 1 {
+						return k, fmt.Errorf("keys.%s: %q types a character, so it can't be used in a text field", binding.action, key)
+					}
+					continue
+				}
+				if k.actions[context] == nil {
+					k.actions[context] = make(map[string]action)
+				}
+				if existing, ok := k.actions[context][key]; ok {
+					return k, fmt.Errorf("keys.%s: %q is already bound to %s", binding.action, key, existing)
+				}
+				k.actions[context][key] = binding.action
+			}
+		}
+	}
+
+	return k, nil
+}
+
+// Get the action bound to a key in the given context, or actionNone if the key is
+// unbound or its action doesn't apply there.
+func (k *keymap) lookup(key string, context keyContext) action {
+	if key == emergencyQuitKey {
+		return actionQuit
+	}
+	a, ok := k.actions[context][key]
+	if !ok {
+		return actionNone
+	}
+	return a
+}
+
+// List the bindings that apply in a context, in display order.
+func (k *keymap) bindingsIn(context keyContext) []keyBinding {
+	var bindings []keyBinding
+	for _, binding := range k.bindings {
+		if slices.Contains(binding.contexts, context) {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings
+}
+
+// Get the binding for an action.
+func (k *keymap) binding(a action) keyBinding {
+	i := slices.IndexFunc(k.bindings, func(b keyBinding) bool {
+		return b.action == a
+	})
+	return k.bindings[i]
+}
+
+// Format a key name for display.
+func displayKey(key string) string {
+	if key == " " {
+		return "space"
+	}
+	return key
+}
+
+// Get a displayable description of all keys bound to an action, like "up/k/w".
+func (k *keymap) describe(a action) string {
+	keys := k.binding(a).keys
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = displayKey(key)
+	}
+	return strings.Join(names, "/")
+}
+
+// Get a displayable description of the keys that trigger an action in a context. Unlike
+// describe, leaves out printable keys that are typed into text fields instead.
+func (k *keymap) describeIn(a action, context keyContext) string {
+	var names []string
+	for _, key := range k.binding(a).keys {
+		if k.actions[context][key] == a {
+			names = append(names, displayKey(key))
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+// Get the keys a confirmation dialog responds to.
+func (k *keymap) confirmKeys() ui.ConfirmKeys {
+	return ui.ConfirmKeys{
+		Yes:    k.binding(actionConfirmYes).keys,
+		Cancel: k.binding(actionConfirmCancel).keys,
+		Switch: k.binding(actionConfirmSwitch).keys,
+		Press:  k.binding(actionConfirmPress).keys,
+	}
+}
+
+// Get the keys a text field responds to while it's being edited.
+func (k *keymap) inputKeys() ui.InputKeys {
+	return ui.InputKeys{
+		Submit: k.binding(actionInputSubmit).keys,
+		Cancel: k.binding(actionInputCancel).keys,
+		Delete: k.binding(actionInputDelete).keys,
+		Clear:  k.binding(actionInputClear).keys,
+	}
+}
+
+// Get the first key bound to an action, for hints like "press . to disconnect".
+func (k *keymap) hint(a action) string {
+	return displayKey(k.binding(a).keys[0])
+}
+
+// Names bubbletea gives to keys that don't type a character, like "enter" or "ctrl+a".
+var keyNames = func() map[string]bool {
+	names := make(map[string]bool)
+	for t := tea.KeyType(-256); t < 256; t++ {
+		if name := t.String(); name != "" && t != tea.KeyRunes {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// Whether bubbletea can report a key by this name, so a binding to it can be pressed.
+func isKnownKey(key string) bool {
+	key = strings.TrimPrefix(key, "alt+")
+	return isPrintableKey(key) || keyNames[key]
+}
+
+// Whether a key types a character, and so can't be used while a text field is focused.
+func isPrintableKey(key string) bool {
+	return utf8.RuneCountInString(key) == 1
+}
+
+// Get the context that currently has keyboard focus.
+func (m *model) keyContext() keyContext {
+	switch {
+	case m.confirm != nil:
+		return keyContextConfirm
+	case m.menu.IsEditing():
+		return keyContextInput
+	case m.state.BackendState != ipn.Running.String():
+		return keyContextBanner
+	case m.menu.IsSubmenuOpen():
+		return keyContextSubmenu
+	default:
+		return keyContextMainMenu
+	}
+}
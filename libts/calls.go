@@ -0,0 +1,109 @@
+package libts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// How long a call to tailscaled can take before it fails. Zero means no limit.
+var Timeout = 10 * time.Second
+
+// A call to tailscaled that hasn't returned yet.
+type Call struct {
+	// What the call is doing, like "editing preferences".
+	Name string
+	// When the call started.
+	Started time.Time
+
+	cancel context.CancelFunc
+}
+
+// Error from a call that timed out or was aborted. Wraps the context's error, so callers
+// can still tell the two apart.
+type callError struct {
+	message string
+	err     error
+}
+
+func (e *callError) Error() string {
+	return e.message
+}
+
+func (e *callError) Unwrap() error {
+	return e.err
+}
+
+// Calls that haven't returned yet, oldest first.
+var calls struct {
+	sync.Mutex
+	running []*Call
+}
+
+// Start tracking a call to tailscaled, with Timeout applied to ctx. Pass the call's error
+// to end when it returns; errors from timeouts and aborts are replaced with ones that say
+// which call it was.
+func startCall(ctx context.Context, name string) (context.Context, func(err error) error) {
+	var cancel context.CancelFunc
+	if Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	call := &Call{Name: name, Started: time.Now(), cancel: cancel}
+
+	calls.Lock()
+	calls.running = append(calls.running, call)
+	calls.Unlock()
+
+	end := func(err error) error {
+		// Check why the context ended before canceling it ourselves.
+		ctxErr := ctx.Err()
+		cancel()
+
+		calls.Lock()
+		calls.running = slices.DeleteFunc(calls.running, func(c *Call) bool {
+			return c == call
+		})
+		calls.Unlock()
+
+		if err == nil {
+			return nil
+		}
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return &callError{fmt.Sprintf("tailscaled took longer than %s %s", Timeout, name), ctxErr}
+		}
+		if errors.Is(ctxErr, context.Canceled) {
+			return &callError{"aborted " + name, ctxErr}
+		}
+		return err
+	}
+	return ctx, end
+}
+
+// Get the call to tailscaled that has been running the longest. Returns false if no calls
+// are running.
+func OldestCall() (Call, bool) {
+	calls.Lock()
+	defer calls.Unlock()
+
+	if len(calls.running) == 0 {
+		return Call{}, false
+	}
+	return *calls.running[0], true
+}
+
+// Abort every running call to tailscaled. Each one fails with an error saying it was
+// aborted. Returns the number of calls aborted.
+func AbortCalls() int {
+	calls.Lock()
+	defer calls.Unlock()
+
+	for _, call := range calls.running {
+		call.cancel()
+	}
+	return len(calls.running)
+}
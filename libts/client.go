@@ -2,8 +2,12 @@ package libts
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/netip"
 
 	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
@@ -13,57 +17,102 @@ var ts tailscale.LocalClient
 
 // Return the Tailscale daemon status. Returns an error if the daemon is not running.
 func Status(ctx context.Context) (*ipnstate.Status, error) {
-	return ts.Status(ctx)
+	ctx, end := startCall(ctx, "fetching the status")
+	status, err := ts.Status(ctx)
+	return status, end(err)
 }
 
 // Start an interactive login flow. This will automatically open the user's web browser.
 // Note that this will NOT DO ANYTHING if the session has already started; i.e. an
 // AuthURL is already populated in the state.
 func StartLoginInteractive(ctx context.Context) error {
+	if DryRun {
+		return &DryRunError{
+			Command: "tailscale login",
+			Request: "POST /localapi/v0/login-interactive",
+		}
+	}
+
+	ctx, end := startCall(ctx, "starting the login")
 	// Workaround for a Tailscale bug (?) where the AuthURL isn't populated when calling
 	// StartLoginInteractive the first time if the user is already logged in. For some reason,
 	// calling Start first with no options makes the AuthURL populate.
 	err := ts.Start(ctx, ipn.Options{})
 	if err != nil {
-		return err
+		return end(err)
 	}
 
-	return ts.StartLoginInteractive(ctx)
+	return end(ts.StartLoginInteractive(ctx))
 }
 
-// Ping a peer.
+// Ping a peer. Pings aren't tracked as calls because they run in the background with their
+// own timeout.
 func PingPeer(ctx context.Context, peer *ipnstate.PeerStatus) (*ipnstate.PingResult, error) {
 	// Discovery ping is the most reliable because it doesn't rely on the host accepting ICMP or anything.
 	// This is what `tailscale ping` uses by default.
 	return ts.Ping(ctx, peer.TailscaleIPs[0], tailcfg.PingDisco)
 }
 
+// Look up the node and user that own a tailnet address. The address must be an IP or IP:port.
+func WhoIs(ctx context.Context, addr string) (*apitype.WhoIsResponse, error) {
+	if _, err := netip.ParseAddr(addr); err != nil {
+		if _, err := netip.ParseAddrPort(addr); err != nil {
+			return nil, fmt.Errorf("%q is not an IP or IP:port", addr)
+		}
+	}
+
+	ctx, end := startCall(ctx, "looking up "+addr)
+	whois, err := ts.WhoIs(ctx, addr)
+	if errors.Is(err, tailscale.ErrPeerNotFound) {
+		end(nil)
+		return nil, fmt.Errorf("no node on the tailnet owns %s", addr)
+	}
+	return whois, end(err)
+}
+
 // Logs you out.
 func Logout(ctx context.Context) error {
-	return ts.Logout(ctx)
+	if DryRun {
+		return &DryRunError{
+			Command: "tailscale logout",
+			Request: "POST /localapi/v0/logout",
+		}
+	}
+	ctx, end := startCall(ctx, "logging out")
+	return end(ts.Logout(ctx))
 }
 
 // Get current preferences.
 func Prefs(ctx context.Context) (*ipn.Prefs, error) {
-	return ts.GetPrefs(ctx)
+	ctx, end := startCall(ctx, "fetching preferences")
+	prefs, err := ts.GetPrefs(ctx)
+	return prefs, end(err)
 }
 
 // Update preferences.
 func EditPrefs(ctx context.Context, maskedPrefs *ipn.MaskedPrefs) error {
+	ctx, end := startCall(ctx, "editing preferences")
+	if DryRun {
+		return end(dryRunEdit(ctx, maskedPrefs))
+	}
 	_, err := ts.EditPrefs(ctx, maskedPrefs)
-	return err
+	return end(err)
 }
 
 // Returns true if the user has write permissions to the Tailscale config.
 // If false, the user may have to run tsui with sudo.
 func CanWrite(ctx context.Context) bool {
-	err := EditPrefs(ctx, &ipn.MaskedPrefs{})
-	return err == nil
+	// An empty edit changes nothing, so it's safe to send even in dry-run mode.
+	ctx, end := startCall(ctx, "checking write access")
+	_, err := ts.EditPrefs(ctx, &ipn.MaskedPrefs{})
+	return end(err) == nil
 }
 
 // Return the tailnet lock status of the current node.
 func LockStatus(ctx context.Context) (*ipnstate.NetworkLockStatus, error) {
-	return ts.NetworkLockStatus(ctx)
+	ctx, end := startCall(ctx, "fetching the tailnet lock status")
+	lock, err := ts.NetworkLockStatus(ctx)
+	return lock, end(err)
 }
 
 // Start the Tailscale daemon.
@@ -88,27 +137,5 @@ func Down(ctx context.Context) error {
 
 // Set the exit node to the given peer, or clear the exit node if peer is nil.
 func SetExitNode(ctx context.Context, peer *ipnstate.PeerStatus) error {
-	var prefs ipn.Prefs
-
-	if peer == nil {
-		prefs.ClearExitNode()
-	} else {
-		status, err := ts.Status(ctx)
-		if err != nil {
-			return err
-		}
-
-		prefs.SetExitNodeIP(peer.TailscaleIPs[0].String(), status)
-	}
-
-	_, err := ts.EditPrefs(ctx, &ipn.MaskedPrefs{
-		Prefs:         prefs,
-		ExitNodeIDSet: true,
-		ExitNodeIPSet: true,
-	})
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return EditPrefs(ctx, ExitNodeEdit(peer))
 }
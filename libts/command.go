@@ -0,0 +1,133 @@
+package libts
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+)
+
+// Get the tailscale CLI command that makes the same change as edit, like
+// "tailscale set --exit-node=100.101.102.103". Exit nodes are named by their Tailscale IP,
+// looked up in peers.
+func CLICommand(edit *ipn.MaskedPrefs, peers []*ipnstate.PeerStatus) string {
+	var flags []string
+	boolFlag := func(name string, value bool) {
+		if value {
+			flags = append(flags, "--"+name)
+		} else {
+			flags = append(flags, "--"+name+"=false")
+		}
+	}
+	stringFlag := func(name string, value string) {
+		flags = append(flags, "--"+name+"="+shellQuote(value))
+	}
+
+	if edit.ShieldsUpSet {
+		boolFlag("shields-up", edit.ShieldsUp)
+	}
+	if edit.RouteAllSet {
+		boolFlag("accept-routes", edit.RouteAll)
+	}
+	if edit.CorpDNSSet {
+		boolFlag("accept-dns", edit.CorpDNS)
+	}
+	if edit.ExitNodeIDSet || edit.ExitNodeIPSet {
+		stringFlag("exit-node", exitNodeArg(edit, peers))
+	}
+	if edit.ExitNodeAllowLANAccessSet {
+		boolFlag("exit-node-allow-lan-access", edit.ExitNodeAllowLANAccess)
+	}
+	if edit.AdvertiseRoutesSet {
+		// The CLI takes the exit node routes as a separate flag.
+		var routes []string
+		for _, route := range edit.AdvertiseRoutes {
+			if !slices.Contains(tsaddr.ExitRoutes(), route) {
+				routes = append(routes, route.String())
+			}
+		}
+		stringFlag("advertise-routes", strings.Join(routes, ","))
+		boolFlag("advertise-exit-node", edit.AdvertisesExitNode())
+	}
+	if edit.RunSSHSet {
+		boolFlag("ssh", edit.RunSSH)
+	}
+	if edit.RunWebClientSet {
+		boolFlag("webclient", edit.RunWebClient)
+	}
+	if edit.HostnameSet {
+		stringFlag("hostname", edit.Hostname)
+	}
+	if edit.AutoUpdateSet.CheckSet {
+		boolFlag("update-check", edit.AutoUpdate.Check)
+	}
+	if edit.AutoUpdateSet.ApplySet {
+		apply, _ := edit.AutoUpdate.Apply.Get()
+		boolFlag("auto-update", apply)
+	}
+	if edit.AppConnectorSet {
+		boolFlag("advertise-connector", edit.AppConnector.Advertise)
+	}
+	if edit.PostureCheckingSet {
+		boolFlag("posture-checking", edit.PostureChecking)
+	}
+	if edit.OperatorUserSet {
+		stringFlag("operator", edit.OperatorUser)
+	}
+	if edit.NoSNATSet {
+		boolFlag("snat-subnet-routes", !edit.NoSNAT)
+	}
+	if edit.NoStatefulFilteringSet {
+		noStatefulFiltering, _ := edit.NoStatefulFiltering.Get()
+		boolFlag("stateful-filtering", !noStatefulFiltering)
+	}
+	if edit.ForceDaemonSet {
+		boolFlag("unattended", edit.ForceDaemon)
+	}
+	if edit.NetfilterModeSet {
+		stringFlag("netfilter-mode", edit.NetfilterMode.String())
+	}
+
+	var commands []string
+	if len(flags) > 0 {
+		commands = append(commands, "tailscale set "+strings.Join(flags, " "))
+	}
+	if edit.WantRunningSet {
+		if edit.WantRunning {
+			commands = append(commands, "tailscale up")
+		} else {
+			commands = append(commands, "tailscale down")
+		}
+	}
+	return strings.Join(commands, " && ")
+}
+
+// Get the --exit-node argument for an edit that changes the exit node.
+func exitNodeArg(edit *ipn.MaskedPrefs, peers []*ipnstate.PeerStatus) string {
+	if edit.ExitNodeID != "" {
+		i := slices.IndexFunc(peers, func(peer *ipnstate.PeerStatus) bool {
+			return peer.ID == edit.ExitNodeID
+		})
+		if i >= 0 && len(peers[i].TailscaleIPs) > 0 {
+			return peers[i].TailscaleIPs[0].String()
+		}
+		return string(edit.ExitNodeID)
+	}
+	if edit.ExitNodeIP.IsValid() {
+		return edit.ExitNodeIP.String()
+	}
+	return ""
+}
+
+var shellSafeRegexp = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]*$`)
+
+// Quote a string for a POSIX shell if it contains special characters.
+func shellQuote(s string) string {
+	if shellSafeRegexp.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,182 @@
+package libts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/types/dnstype"
+)
+
+// DNS record types that can be passed to QueryDNS.
+var DNSQueryTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "PTR", "SRV", "TXT"}
+
+// Result of a DNS query made through tailscaled's resolver.
+type DNSQueryResult struct {
+	// Response code of the query, such as "Success" or "NameError".
+	ResponseCode string
+	// Answer records formatted for display, such as "A 100.101.102.103".
+	Answers []string
+	// Upstream resolvers that answered the query. Empty if tailscaled answered it
+	// itself, e.g. for MagicDNS names.
+	Resolvers []*dnstype.Resolver
+}
+
+// Shape of the LocalAPI's dns-query response.
+type dnsQueryResponse struct {
+	Bytes     []byte
+	Resolvers []*dnstype.Resolver
+}
+
+// Resolve a name through tailscaled's DNS resolver, the same one used by the OS when
+// "Use DNS Settings" is on. queryType is one of DNSQueryTypes.
+func QueryDNS(ctx context.Context, name string, queryType string) (*DNSQueryResult, error) {
+	ctx, end := startCall(ctx, "resolving "+name)
+	result, err := queryDNS(ctx, name, queryType)
+	return result, end(err)
+}
+
+func queryDNS(ctx context.Context, name string, queryType string) (*DNSQueryResult, error) {
+	// Our tailscale.LocalClient version predates its QueryDNS wrapper, so call the LocalAPI
+	// endpoint ourselves.
+	query := url.Values{"name": {name}, "type": {queryType}}
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		"http://"+apitype.LocalAPIHost+"/localapi/v0/dns-query?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ts.DoLocalRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("this version of tailscaled doesn't support DNS queries")
+	default:
+		return nil, fmt.Errorf("DNS query failed: %s", strings.TrimSpace(string(body)))
+	}
+
+	var queryResp dnsQueryResponse
+	err = json.Unmarshal(body, &queryResp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS query response: %w", err)
+	}
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(queryResp.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS message: %w", err)
+	}
+	err = parser.SkipAllQuestions()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS message: %w", err)
+	}
+
+	result := &DNSQueryResult{
+		ResponseCode: strings.TrimPrefix(header.RCode.String(), "RCode"),
+		Resolvers:    queryResp.Resolvers,
+	}
+
+	for {
+		answerHeader, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS message: %w", err)
+		}
+
+		answer, err := formatDNSAnswer(&parser, answerHeader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS message: %w", err)
+		}
+		result.Answers = append(result.Answers, answer)
+	}
+
+	return result, nil
+}
+
+// Parse the body of the current answer record and format it as "TYPE value".
+func formatDNSAnswer(parser *dnsmessage.Parser, header dnsmessage.ResourceHeader) (string, error) {
+	typeName := strings.TrimPrefix(header.Type.String(), "Type")
+
+	switch header.Type {
+	case dnsmessage.TypeA:
+		r, err := parser.AResource()
+		if err != nil {
+			return "", err
+		}
+		return typeName + " " + netip.AddrFrom4(r.A).String(), nil
+
+	case dnsmessage.TypeAAAA:
+		r, err := parser.AAAAResource()
+		if err != nil {
+			return "", err
+		}
+		return typeName + " " + netip.AddrFrom16(r.AAAA).String(), nil
+
+	case dnsmessage.TypeCNAME:
+		r, err := parser.CNAMEResource()
+		if err != nil {
+			return "", err
+		}
+		return typeName + " " + r.CNAME.String(), nil
+
+	case dnsmessage.TypeMX:
+		r, err := parser.MXResource()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %d %s", typeName, r.Pref, r.MX), nil
+
+	case dnsmessage.TypeNS:
+		r, err := parser.NSResource()
+		if err != nil {
+			return "", err
+		}
+		return typeName + " " + r.NS.String(), nil
+
+	case dnsmessage.TypePTR:
+		r, err := parser.PTRResource()
+		if err != nil {
+			return "", err
+		}
+		return typeName + " " + r.PTR.String(), nil
+
+	case dnsmessage.TypeSRV:
+		r, err := parser.SRVResource()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %d %d %d %s", typeName, r.Priority, r.Weight, r.Port, r.Target), nil
+
+	case dnsmessage.TypeTXT:
+		r, err := parser.TXTResource()
+		if err != nil {
+			return "", err
+		}
+		return typeName + " " + strings.Join(r.TXT, " "), nil
+	}
+
+	err := parser.SkipAnswer()
+	if err != nil {
+		return "", err
+	}
+	return typeName, nil
+}
@@ -0,0 +1,54 @@
+package libts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// If true, calls that would change Tailscale's state return a *DryRunError describing the
+// request instead of sending it. Reads still go to the daemon.
+var DryRun bool
+
+// Error returned instead of making a change in dry-run mode.
+type DryRunError struct {
+	// Equivalent tailscale CLI command, like "tailscale set --shields-up".
+	Command string
+	// LocalAPI request that would have been made, like "PATCH /localapi/v0/prefs".
+	Request string
+	// Exact JSON body of the request, or empty if it has none.
+	Body string
+}
+
+func (e *DryRunError) Error() string {
+	return fmt.Sprintf("dry run: would run %s", e.Command)
+}
+
+// Describe the preference edit that would have been sent.
+func dryRunEdit(ctx context.Context, maskedPrefs *ipn.MaskedPrefs) error {
+	body, err := json.Marshal(maskedPrefs)
+	if err != nil {
+		return err
+	}
+
+	// Look up peers so exit nodes can be named by IP in the command.
+	var peers []*ipnstate.PeerStatus
+	if maskedPrefs.ExitNodeID != "" {
+		status, err := Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, peer := range status.Peer {
+			peers = append(peers, peer)
+		}
+	}
+
+	return &DryRunError{
+		Command: CLICommand(maskedPrefs, peers),
+		Request: "PATCH /localapi/v0/prefs",
+		Body:    string(body),
+	}
+}
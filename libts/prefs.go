@@ -0,0 +1,91 @@
+package libts
+
+import (
+	"reflect"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// Make the preference edit that sets the exit node to the given peer, or clears the exit
+// node if peer is nil.
+func ExitNodeEdit(peer *ipnstate.PeerStatus) *ipn.MaskedPrefs {
+	var prefs ipn.Prefs
+	if peer == nil {
+		prefs.ClearExitNode()
+	} else {
+		prefs.ExitNodeID = peer.ID
+	}
+
+	return &ipn.MaskedPrefs{
+		Prefs:         prefs,
+		ExitNodeIDSet: true,
+		ExitNodeIPSet: true,
+	}
+}
+
+// Make the preference edit that undoes edit, by setting exactly the fields it changes back
+// to their values in prefs, the preferences from before the edit was applied.
+func RevertEdit(prefs *ipn.Prefs, edit *ipn.MaskedPrefs) *ipn.MaskedPrefs {
+	revert := &ipn.MaskedPrefs{}
+	copyMaskedFields(
+		reflect.ValueOf(edit).Elem(),
+		reflect.ValueOf(prefs).Elem(),
+		reflect.ValueOf(revert).Elem(),
+		reflect.ValueOf(&revert.Prefs).Elem(),
+	)
+	return revert
+}
+
+// Combine edits into one, as if they were applied in order.
+func MergeEdits(edits ...*ipn.MaskedPrefs) *ipn.MaskedPrefs {
+	merged := &ipn.MaskedPrefs{}
+	for _, edit := range edits {
+		copyMaskedFields(
+			reflect.ValueOf(edit).Elem(),
+			reflect.ValueOf(&edit.Prefs).Elem(),
+			reflect.ValueOf(merged).Elem(),
+			reflect.ValueOf(&merged.Prefs).Elem(),
+		)
+	}
+	return merged
+}
+
+// For each FooSet field of mask that's true, copy the Foo field of from to the Foo field of
+// to, and set FooSet in toMask. Struct-valued masks like AutoUpdateSet are handled
+// recursively, with their fields mapping to the fields of the corresponding Prefs struct.
+func copyMaskedFields(mask reflect.Value, from reflect.Value, toMask reflect.Value, to reflect.Value) {
+	maskType := mask.Type()
+	for i := range maskType.NumField() {
+		name, isMask := strings.CutSuffix(maskType.Field(i).Name, "Set")
+		if !isMask {
+			continue
+		}
+		fromField := from.FieldByName(name)
+		if !fromField.IsValid() {
+			continue
+		}
+
+		maskField := mask.Field(i)
+		switch maskField.Kind() {
+		case reflect.Bool:
+			if maskField.Bool() {
+				to.FieldByName(name).Set(cloneValue(fromField))
+				toMask.Field(i).SetBool(true)
+			}
+		case reflect.Struct:
+			copyMaskedFields(maskField, fromField, toMask.Field(i), to.FieldByName(name))
+		}
+	}
+}
+
+// Copy a value so slices aren't shared with the original.
+func cloneValue(v reflect.Value) reflect.Value {
+	if v.Kind() != reflect.Slice || v.IsNil() {
+		return v
+	}
+	clone := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(clone, v)
+	return clone
+}
@@ -0,0 +1,155 @@
+package libts
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
+	"tailscale.com/types/preftype"
+)
+
+// Preferences with a non-default value in every field the tests edit.
+func testPrefs() *ipn.Prefs {
+	prefs := ipn.NewPrefs()
+	prefs.ShieldsUp = true
+	prefs.RouteAll = false
+	prefs.ExitNodeID = "node-a"
+	prefs.ExitNodeAllowLANAccess = true
+	prefs.AdvertiseRoutes = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}
+	prefs.Hostname = "laptop"
+	prefs.AutoUpdate.Check = false
+	prefs.AutoUpdate.Apply = opt.NewBool(true)
+	prefs.NetfilterMode = preftype.NetfilterNoDivert
+	return prefs
+}
+
+// Get just the mask of an edit, with the preference values cleared.
+func maskOf(edit *ipn.MaskedPrefs) ipn.MaskedPrefs {
+	mask := *edit
+	mask.Prefs = ipn.Prefs{}
+	return mask
+}
+
+var revertTests = []struct {
+	name string
+	edit *ipn.MaskedPrefs
+}{
+	{"bool", &ipn.MaskedPrefs{
+		Prefs:        ipn.Prefs{ShieldsUp: false},
+		ShieldsUpSet: true,
+	}},
+	{"several fields", &ipn.MaskedPrefs{
+		Prefs:                     ipn.Prefs{RouteAll: true, ExitNodeAllowLANAccess: false, Hostname: "desktop"},
+		RouteAllSet:               true,
+		ExitNodeAllowLANAccessSet: true,
+		HostnameSet:               true,
+	}},
+	{"exit node", ExitNodeEdit(nil)},
+	{"routes", &ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}},
+		AdvertiseRoutesSet: true,
+	}},
+	{"nested", &ipn.MaskedPrefs{
+		Prefs:         ipn.Prefs{AutoUpdate: ipn.AutoUpdatePrefs{Check: true}},
+		AutoUpdateSet: ipn.AutoUpdatePrefsMask{CheckSet: true},
+	}},
+	{"enum", &ipn.MaskedPrefs{
+		Prefs:            ipn.Prefs{NetfilterMode: preftype.NetfilterOff},
+		NetfilterModeSet: true,
+	}},
+}
+
+func TestRevertEdit(t *testing.T) {
+	for _, test := range revertTests {
+		t.Run(test.name, func(t *testing.T) {
+			before := testPrefs()
+			revert := RevertEdit(before, test.edit)
+
+			if got, want := maskOf(revert), maskOf(test.edit); !reflect.DeepEqual(got, want) {
+				t.Errorf("revert mask = %+v, want %+v", got, want)
+			}
+
+			after := before.Clone()
+			after.ApplyEdits(test.edit)
+			if after.Equals(before) {
+				t.Fatalf("edit didn't change anything, so the test can't tell if it was reverted")
+			}
+			after.ApplyEdits(revert)
+			if !after.Equals(before) {
+				t.Errorf("prefs after reverting = %s, want %s", after.Pretty(), before.Pretty())
+			}
+		})
+	}
+}
+
+func TestRevertEditDoesNotShareSlices(t *testing.T) {
+	before := testPrefs()
+	revert := RevertEdit(before, &ipn.MaskedPrefs{AdvertiseRoutesSet: true})
+
+	before.AdvertiseRoutes[0] = netip.MustParsePrefix("192.168.0.0/24")
+	if got := revert.AdvertiseRoutes[0].String(); got != "10.0.0.0/24" {
+		t.Errorf("revert route = %s after changing the original, want 10.0.0.0/24", got)
+	}
+}
+
+func TestMergeEdits(t *testing.T) {
+	exitNode := &ipn.MaskedPrefs{
+		Prefs:         ipn.Prefs{ExitNodeID: tailcfg.StableNodeID("node-b")},
+		ExitNodeIDSet: true,
+		ExitNodeIPSet: true,
+	}
+	shieldsOn := &ipn.MaskedPrefs{Prefs: ipn.Prefs{ShieldsUp: true}, ShieldsUpSet: true}
+	shieldsOff := &ipn.MaskedPrefs{Prefs: ipn.Prefs{ShieldsUp: false}, ShieldsUpSet: true}
+	autoUpdate := &ipn.MaskedPrefs{
+		Prefs:         ipn.Prefs{AutoUpdate: ipn.AutoUpdatePrefs{Apply: opt.NewBool(false)}},
+		AutoUpdateSet: ipn.AutoUpdatePrefsMask{ApplySet: true},
+	}
+
+	tests := []struct {
+		name  string
+		edits []*ipn.MaskedPrefs
+		want  *ipn.MaskedPrefs
+	}{
+		{"none", nil, &ipn.MaskedPrefs{}},
+		{"one", []*ipn.MaskedPrefs{shieldsOn}, shieldsOn},
+		{"later wins", []*ipn.MaskedPrefs{shieldsOn, shieldsOff}, shieldsOff},
+		{"union", []*ipn.MaskedPrefs{exitNode, shieldsOn, autoUpdate}, &ipn.MaskedPrefs{
+			Prefs: ipn.Prefs{
+				ExitNodeID: "node-b",
+				ShieldsUp:  true,
+				AutoUpdate: ipn.AutoUpdatePrefs{Apply: opt.NewBool(false)},
+			},
+			ExitNodeIDSet: true,
+			ExitNodeIPSet: true,
+			ShieldsUpSet:  true,
+			AutoUpdateSet: ipn.AutoUpdatePrefsMask{ApplySet: true},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := MergeEdits(test.edits...)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("MergeEdits = %s, want %s", got.Pretty(), test.want.Pretty())
+			}
+		})
+	}
+}
+
+func TestMergedEditRevertsInOneStep(t *testing.T) {
+	before := testPrefs()
+	edits := make([]*ipn.MaskedPrefs, len(revertTests))
+	for i, test := range revertTests {
+		edits[i] = test.edit
+	}
+	merged := MergeEdits(edits...)
+
+	after := before.Clone()
+	after.ApplyEdits(merged)
+	after.ApplyEdits(RevertEdit(before, merged))
+	if !after.Equals(before) {
+		t.Errorf("prefs after reverting = %s, want %s", after.Pretty(), before.Pretty())
+	}
+}
@@ -0,0 +1,125 @@
+package libts
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"tailscale.com/ipn"
+)
+
+// A preference and its value, for display.
+type PrefsField struct {
+	// Dotted field name, like "AutoUpdate.Check".
+	Name string
+	// Value as JSON, or by name for enums.
+	Value string
+	// Whether the value is the same as in a new install's preferences.
+	IsDefault bool
+}
+
+// List every field of prefs except Persist, with nested structs like AutoUpdate flattened,
+// and whether each differs from the defaults.
+func PrefsFields(prefs *ipn.Prefs) []PrefsField {
+	var fields []PrefsField
+	appendFields(&fields, "", reflect.ValueOf(prefs).Elem(), reflect.ValueOf(ipn.NewPrefs()).Elem())
+	return fields
+}
+
+// Add the fields of value to fields, comparing each with the same field of defaultValue.
+func appendFields(fields *[]PrefsField, prefix string, value reflect.Value, defaultValue reflect.Value) {
+	valueType := value.Type()
+	for i := range valueType.NumField() {
+		field := valueType.Field(i)
+		// Persist holds the node's private keys, so it's only shown through PersistFields.
+		if !field.IsExported() || field.Name == "Persist" {
+			continue
+		}
+
+		name := prefix + field.Name
+		if field.Type.Kind() == reflect.Struct && field.Type.PkgPath() == valueType.PkgPath() {
+			appendFields(fields, name+".", value.Field(i), defaultValue.Field(i))
+			continue
+		}
+
+		encoded := encodeField(value.Field(i))
+		*fields = append(*fields, PrefsField{
+			Name:      name,
+			Value:     encoded,
+			IsDefault: encoded == encodeField(defaultValue.Field(i)),
+		})
+	}
+}
+
+// Encode a field's value as JSON, except for enums like NetfilterMode which are shown by
+// name instead of number. Values that encode the same, like the two forms of an unset
+// opt.Bool, count as equal.
+func encodeField(value reflect.Value) string {
+	if stringer, ok := value.Interface().(fmt.Stringer); ok && value.Kind() == reflect.Int {
+		return stringer.String()
+	}
+	encoded, err := json.Marshal(value.Interface())
+	if err != nil {
+		return "(" + err.Error() + ")"
+	}
+	return string(encoded)
+}
+
+// List the identifying info from prefs.Persist, with the private keys left out. Only their
+// public halves are shown.
+func PersistFields(prefs *ipn.Prefs) []PrefsField {
+	persist := prefs.Persist
+	if persist == nil {
+		return nil
+	}
+
+	var fields []PrefsField
+	add := func(name string, value any) {
+		encoded, _ := json.Marshal(value)
+		fields = append(fields, PrefsField{Name: name, Value: string(encoded)})
+	}
+	add("Persist.NodeID", persist.NodeID)
+	add("Persist.UserProfile.LoginName", persist.UserProfile.LoginName)
+	add("Persist.UserProfile.DisplayName", persist.UserProfile.DisplayName)
+	if !persist.PrivateNodeKey.IsZero() {
+		add("Persist.PublicNodeKey", persist.PrivateNodeKey.Public())
+	}
+	if !persist.NetworkLockKey.IsZero() {
+		add("Persist.PublicNetworkLockKey", persist.NetworkLockKey.Public().CLIString())
+	}
+	if len(persist.DisallowedTKAStateIDs) > 0 {
+		add("Persist.DisallowedTKAStateIDs", persist.DisallowedTKAStateIDs)
+	}
+	return fields
+}
+
+// Encode prefs as indented JSON, with Persist replaced by the redacted info from
+// PersistFields so no private keys are included.
+func RedactedPrefsJSON(prefs *ipn.Prefs) (string, error) {
+	redacted := *prefs
+	redacted.Persist = nil
+
+	encoded, err := json.Marshal(&redacted)
+	if err != nil {
+		return "", err
+	}
+	var object map[string]any
+	err = json.Unmarshal(encoded, &object)
+	if err != nil {
+		return "", err
+	}
+
+	if prefs.Persist != nil {
+		persist := make(map[string]json.RawMessage)
+		for _, field := range PersistFields(prefs) {
+			persist[field.Name[len("Persist."):]] = json.RawMessage(field.Value)
+		}
+		object["Config"] = persist
+	}
+
+	indented, err := json.MarshalIndent(object, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(indented), nil
+}
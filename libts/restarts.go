@@ -0,0 +1,81 @@
+package libts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// Keeps track of tailscaled restarts by staying subscribed to its IPN bus. The
+// subscription drops when tailscaled exits, so each new one is a new run of tailscaled.
+type RestartWatcher struct {
+	mu sync.Mutex
+	// Number of times the subscription was made. Increases on every restart.
+	run int
+	// Whether the subscription is currently up.
+	connected bool
+}
+
+// Start watching for tailscaled restarts until ctx is done. Returns once the first attempt
+// to subscribe is over, so Run can be used right away.
+func WatchRestarts(ctx context.Context) *RestartWatcher {
+	w := &RestartWatcher{}
+	ready := make(chan struct{})
+	go w.watch(ctx, ready)
+	select {
+	case <-ready:
+	case <-ctx.Done():
+	}
+	return w
+}
+
+func (w *RestartWatcher) watch(ctx context.Context, ready chan struct{}) {
+	var once sync.Once
+	markReady := func() {
+		once.Do(func() { close(ready) })
+	}
+	defer markReady()
+
+	for ctx.Err() == nil {
+		watcher, err := ts.WatchIPNBus(ctx, ipn.NotifyInitialState)
+		if err != nil {
+			markReady()
+			// tailscaled isn't up yet, so try again shortly.
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		// The initial state only arrives once the subscription is really up.
+		_, err = watcher.Next()
+		if err == nil {
+			w.mu.Lock()
+			w.run++
+			w.connected = true
+			w.mu.Unlock()
+			markReady()
+
+			for err == nil {
+				_, err = watcher.Next()
+			}
+		}
+
+		w.mu.Lock()
+		w.connected = false
+		w.mu.Unlock()
+		watcher.Close()
+		markReady()
+	}
+}
+
+// Get the number of the current run of tailscaled. Returns false if tailscaled isn't
+// reachable right now, like while it's restarting.
+func (w *RestartWatcher) Run() (int, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.run, w.connected
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
@@ -30,12 +32,21 @@ type State struct {
 
 	// Peer status of the local node.
 	Self *ipnstate.PeerStatus
+	// Info about the tailnet the node is connected to, including its MagicDNS settings.
+	// Nil if not connected.
+	Tailnet *ipnstate.TailnetStatus
 
 	// Tailnet lock key. Nil if not enabled.
 	LockKey *key.NLPublic
 	// True if the node is locked out by tailnet lock.
 	IsLockedOut bool
 
+	// Why optional parts of the state couldn't be fetched, by part, like SectionLock. Those
+	// parts are left empty. Nil if everything was fetched.
+	Unavailable map[string]error
+
+	// List of all peers, alphabetically pre-sorted by the result of the PeerName function.
+	SortedPeers []*ipnstate.PeerStatus
 	// List of exit node peers, alphabetically pre-sorted by the result of the PeerName function.
 	SortedExitNodes []*ipnstate.PeerStatus
 	// ID of the currently selected exit node or nil if none is selected.
@@ -47,45 +58,84 @@ type State struct {
 	RxBytes int64
 	// Total bytes sent to peers.
 	TxBytes int64
+
+	// When fetching the state started. Changes that finished before this are reflected in it.
+	Requested time.Time
 }
 
-// Get a sorted list of exit node peers, alphabetically pre-sorted by the result of the PeerName function.
-func getSortedExitNodes(tsStatus *ipnstate.Status) []*ipnstate.PeerStatus {
-	exitNodes := make([]*ipnstate.PeerStatus, 0)
+// Get a list of all peers, alphabetically pre-sorted by the result of the PeerName function.
+func getSortedPeers(tsStatus *ipnstate.Status) []*ipnstate.PeerStatus {
+	peers := make([]*ipnstate.PeerStatus, 0)
 
 	if tsStatus == nil {
-		return exitNodes
+		return peers
 	}
 
 	for _, peer := range tsStatus.Peer {
-		if peer.ExitNodeOption {
-			exitNodes = append(exitNodes, peer)
-		}
+		peers = append(peers, peer)
 	}
 
-	slices.SortFunc(exitNodes, func(a, b *ipnstate.PeerStatus) int {
+	slices.SortFunc(peers, func(a, b *ipnstate.PeerStatus) int {
 		return strings.Compare(PeerName(a), PeerName(b))
 	})
 
+	return peers
+}
+
+// Get a sorted list of exit node peers, alphabetically pre-sorted by the result of the PeerName function.
+func getSortedExitNodes(sortedPeers []*ipnstate.PeerStatus) []*ipnstate.PeerStatus {
+	exitNodes := make([]*ipnstate.PeerStatus, 0)
+
+	for _, peer := range sortedPeers {
+		if peer.ExitNodeOption {
+			exitNodes = append(exitNodes, peer)
+		}
+	}
+
 	return exitNodes
 }
 
-// Make a current State by making necessary Tailscale API calls.
+// Optional part of the state with the tailnet lock status. Older versions of tailscaled
+// can't report it.
+const SectionLock = "tailnet lock"
+
+// Make a current State by making necessary Tailscale API calls at the same time. Fails if
+// the status or preferences can't be fetched. Other parts are optional and are recorded in
+// State.Unavailable if they fail.
 func GetState(ctx context.Context) (State, error) {
-	status, err := Status(ctx)
-	if err != nil {
-		return State{}, err
+	requested := time.Now()
+	var (
+		wg        sync.WaitGroup
+		status    *ipnstate.Status
+		statusErr error
+		prefs     *ipn.Prefs
+		prefsErr  error
+		lock      *ipnstate.NetworkLockStatus
+		lockErr   error
+	)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		status, statusErr = Status(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		prefs, prefsErr = Prefs(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		lock, lockErr = LockStatus(ctx)
+	}()
+	wg.Wait()
+
+	if statusErr != nil {
+		return State{}, statusErr
 	}
-
-	prefs, err := Prefs(ctx)
-	if err != nil {
-		return State{}, err
+	if prefsErr != nil {
+		return State{}, prefsErr
 	}
 
-	lock, err := LockStatus(ctx)
-	if err != nil {
-		return State{}, err
-	}
+	sortedPeers := getSortedPeers(status)
 
 	state := State{
 		Prefs:           prefs,
@@ -93,7 +143,10 @@ func GetState(ctx context.Context) (State, error) {
 		BackendState:    status.BackendState,
 		TSVersion:       status.Version,
 		Self:            status.Self,
-		SortedExitNodes: getSortedExitNodes(status),
+		Tailnet:         status.CurrentTailnet,
+		SortedPeers:     sortedPeers,
+		SortedExitNodes: getSortedExitNodes(sortedPeers),
+		Requested:       requested,
 	}
 
 	for _, peer := range status.Peer {
@@ -111,7 +164,9 @@ func GetState(ctx context.Context) (State, error) {
 		state.User = &user
 	}
 
-	if lock.Enabled && lock.NodeKey != nil && !lock.PublicKey.IsZero() {
+	if lockErr != nil {
+		state.Unavailable = map[string]error{SectionLock: lockErr}
+	} else if lock.Enabled && lock.NodeKey != nil && !lock.PublicKey.IsZero() {
 		state.LockKey = &lock.PublicKey
 
 		if !lock.NodeKeySigned && state.BackendState == ipn.Running.String() {
@@ -3,23 +3,30 @@ package main
 import (
 	"fmt"
 	"math"
-	"runtime"
+	"slices"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/neuralinkcorp/tsui/clipboard"
 	"github.com/neuralinkcorp/tsui/libts"
 	"github.com/neuralinkcorp/tsui/ui"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
-	"tailscale.com/types/opt"
-	"tailscale.com/types/preftype"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
 )
 
+// Maximum number of peers to list in the bandwidth submenu.
+const maxTopTalkers = 15
+
 // Update all of the menu UIs from the current state.
 func (m *model) updateMenus() {
 	if m.state.BackendState == ipn.Running.String() {
 		// Update the device info submenu.
 		{
+			disconnectTip := fmt.Sprintf("You can also simply press %s to disconnect.", m.keys.hint(actionToggleConnection))
+
 			submenuItems := []ui.SubmenuItem{
 				&ui.TitleSubmenuItem{Label: "Name"},
 				&ui.LabeledSubmenuItem{
@@ -82,7 +89,17 @@ func (m *model) updateMenus() {
 				},
 			)
 
-			if m.state.LockKey != nil {
+			if err := m.state.Unavailable[libts.SectionLock]; err != nil {
+				// Older versions of tailscaled can't report the lock status, so don't guess.
+				submenuItems = append(submenuItems,
+					&ui.SpacerSubmenuItem{},
+					&ui.TitleSubmenuItem{Label: "Tailnet Lock: Unknown"},
+					&ui.LabeledSubmenuItem{
+						Label: err.Error(),
+						IsDim: true,
+					},
+				)
+			} else if m.state.LockKey != nil {
 				statusText := "Online"
 				if m.state.IsLockedOut {
 					statusText = "Locked Out"
@@ -109,12 +126,13 @@ func (m *model) updateMenus() {
 				&ui.LabeledSubmenuItem{
 					Label:   "[Disconnect from Tailscale]",
 					Variant: ui.SubmenuItemVariantAccent,
+					Confirm: m.disconnectConfirm(),
 					OnActivate: func() tea.Msg {
 						err := libts.Down(ctx)
 						if err != nil {
 							return errorMsg(err)
 						}
-						return tipMsg("You can also simply press . to disconnect.")
+						return commandRanMsg{"Disconnect", "tailscale down", tipMsg(disconnectTip)}
 					},
 				},
 			)
@@ -124,19 +142,58 @@ func (m *model) updateMenus() {
 
 		// Update the exit node submenu.
 		{
+			// In staged edit mode, the pending exit node is shown as the active one.
+			var pendingExitNode string
+			if edit := m.pendingEdit("exit-node"); edit != nil {
+				pendingExitNode = edit.value
+			}
+			// A change that's being applied is shown as the active one too.
+			if edit := m.inFlight["exit-node"]; edit != nil {
+				pendingExitNode = edit.value
+			}
+			running := m.runningEdit("exit-node")
+			isActive := func(name string, current bool) bool {
+				if pendingExitNode != "" {
+					return name == pendingExitNode
+				}
+				return current
+			}
+			pendingLabel := func(name string, additionalLabel string) string {
+				if name == pendingExitNode {
+					return "Pending"
+				}
+				return additionalLabel
+			}
+			selectExitNode := func(peer *ipnstate.PeerStatus) tea.Cmd {
+				if m.staging {
+					return func() tea.Msg {
+						return stageEditMsg(stageExitNode(peer))
+					}
+				}
+				name := "None"
+				if peer != nil {
+					name = libts.PeerName(peer)
+				}
+				return func() tea.Msg {
+					return inFlightMsg{
+						key:   "exit-node",
+						value: name,
+						cmd: func() tea.Msg {
+							return editPrefs("Exit Node: "+name, libts.ExitNodeEdit(peer))
+						},
+					}
+				}
+			}
+
 			exitNodeItems := make([]ui.SubmenuItem, 2+len(m.state.SortedExitNodes))
 			exitNodeItems[0] = &ui.ToggleableSubmenuItem{
 				LabeledSubmenuItem: ui.LabeledSubmenuItem{
-					Label: "None",
-					OnActivate: func() tea.Msg {
-						err := libts.SetExitNode(ctx, nil)
-						if err != nil {
-							return errorMsg(err)
-						}
-						return updateState()
-					},
+					Label:           "None",
+					AdditionalLabel: pendingLabel("None", ""),
+					OnActivate:      selectExitNode(nil),
 				},
-				IsActive: m.state.CurrentExitNode == nil,
+				IsActive:   isActive("None", m.state.CurrentExitNode == nil),
+				IsInFlight: running != nil && running.value == "None",
 			}
 			exitNodeItems[1] = &ui.DividerSubmenuItem{}
 			for i, exitNode := range m.state.SortedExitNodes {
@@ -144,26 +201,25 @@ func (m *model) updateMenus() {
 				i += 2
 
 				pingLabel := "???"
+				if !m.config.Ping.Enabled {
+					pingLabel = ""
+				}
 				if !exitNode.Online {
 					pingLabel = "Offline"
 				} else if m.pings[exitNode.ID] != nil {
 					pingLabel = fmt.Sprintf("%dms", int(math.Round(m.pings[exitNode.ID].LatencySeconds*1000)))
 				}
 
+				name := libts.PeerName(exitNode)
 				exitNodeItems[i] = &ui.ToggleableSubmenuItem{
 					LabeledSubmenuItem: ui.LabeledSubmenuItem{
-						Label:           libts.PeerName(exitNode),
-						AdditionalLabel: pingLabel,
-						OnActivate: func() tea.Msg {
-							err := libts.SetExitNode(ctx, exitNode)
-							if err != nil {
-								return errorMsg(err)
-							}
-							return updateState()
-						},
-						IsDim: !exitNode.Online,
+						Label:           name,
+						AdditionalLabel: pendingLabel(name, pingLabel),
+						OnActivate:      selectExitNode(exitNode),
+						IsDim:           !exitNode.Online,
 					},
-					IsActive: m.state.CurrentExitNode != nil && exitNode.ID == *m.state.CurrentExitNode,
+					IsActive:   isActive(name, m.state.CurrentExitNode != nil && exitNode.ID == *m.state.CurrentExitNode),
+					IsInFlight: running != nil && running.value == name,
 				}
 			}
 
@@ -173,11 +229,6 @@ func (m *model) updateMenus() {
 
 		// Update the settings submenu.
 		{
-			exitNode := "No"
-			if m.state.Prefs.AdvertisesExitNode() {
-				exitNode = "Exit Node"
-			}
-
 			accountTitle := "Account"
 			reauthenticateButtonLabel := "[Reauthenticate]"
 			if m.state.Self.KeyExpiry != nil {
@@ -187,72 +238,31 @@ func (m *model) updateMenus() {
 				accountTitle += " - Key Expires in " + ui.FormatDuration(duration)
 			}
 
-			submenuItems := []ui.SubmenuItem{
-				&ui.TitleSubmenuItem{Label: "General"},
-
-				ui.NewYesNoSettingsSubmenuItem("Allow Incoming Connections",
-					!m.state.Prefs.ShieldsUp,
-					func(newValue bool) tea.Msg {
-						return editPrefs(&ipn.MaskedPrefs{
-							Prefs: ipn.Prefs{
-								ShieldsUp: !newValue,
-							},
-							ShieldsUpSet: true,
-						})
-					},
-				),
+			stagingMode := "Off"
+			if m.staging {
+				stagingMode = "On"
+			}
 
-				ui.NewYesNoSettingsSubmenuItem("Use Subnet Routes",
-					m.state.Prefs.RouteAll,
-					func(newValue bool) tea.Msg {
-						return editPrefs(&ipn.MaskedPrefs{
-							Prefs: ipn.Prefs{
-								RouteAll: newValue,
-							},
-							RouteAllSet: true,
-						})
-					},
-				),
+			submenuItems := []ui.SubmenuItem{
+				ui.NewSettingsSubmenuItem("Stage Changes", []string{"Off", "On"}, stagingMode, func(newValue string) tea.Msg {
+					return stagingModeMsg(newValue == "On")
+				}),
+			}
+			if m.staging {
+				submenuItems = append(submenuItems, m.pendingSubmenuItems()...)
+			}
 
-				ui.NewYesNoSettingsSubmenuItem("Use DNS Settings",
-					m.state.Prefs.CorpDNS,
-					func(newValue bool) tea.Msg {
-						return editPrefs(&ipn.MaskedPrefs{
-							Prefs: ipn.Prefs{
-								CorpDNS: newValue,
-							},
-							CorpDNSSet: true,
-						})
-					},
-				),
+			submenuItems = append(submenuItems,
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: "General"},
+				m.settingSubmenuItem(allowIncomingSetting),
+				m.settingSubmenuItem(useSubnetRoutesSetting),
+				m.settingSubmenuItem(useDNSSettingsSetting),
 
 				&ui.SpacerSubmenuItem{},
 				&ui.TitleSubmenuItem{Label: "Exit Nodes"},
-
-				ui.NewYesNoSettingsSubmenuItem("Enable Local Network Access",
-					m.state.Prefs.ExitNodeAllowLANAccess,
-					func(newValue bool) tea.Msg {
-						return editPrefs(&ipn.MaskedPrefs{
-							Prefs: ipn.Prefs{
-								ExitNodeAllowLANAccess: newValue,
-							},
-							ExitNodeAllowLANAccessSet: true,
-						})
-					},
-				),
-
-				ui.NewSettingsSubmenuItem("Advertise Exit Node",
-					[]string{"Exit Node", "No"},
-					exitNode,
-					func(newLabel string) tea.Msg {
-						var prefs ipn.Prefs
-						prefs.SetAdvertiseExitNode(newLabel == "Exit Node")
-						return editPrefs(&ipn.MaskedPrefs{
-							Prefs:              prefs,
-							AdvertiseRoutesSet: true,
-						})
-					},
-				),
+				m.settingSubmenuItem(localNetworkAccessSetting),
+				m.settingSubmenuItem(advertiseExitNodeSetting),
 
 				&ui.SpacerSubmenuItem{},
 				&ui.TitleSubmenuItem{Label: accountTitle},
@@ -265,79 +275,439 @@ func (m *model) updateMenus() {
 						if err != nil {
 							return errorMsg(err)
 						}
-						return successMsg("Starting reauthentication. This may take a few seconds.")
+						return commandRanMsg{"Reauthenticate", "tailscale login", successMsg("Starting reauthentication. This may take a few seconds.")}
 					},
 				},
 
 				&ui.LabeledSubmenuItem{
 					Label:   "[Log Out]",
 					Variant: ui.SubmenuItemVariantDanger,
+					Confirm: m.makeConfirm(m.config.Confirm.LogOut,
+						"Log out of Tailscale?",
+						"This device will disconnect and you'll have to log in again to rejoin the tailnet.",
+						"Log Out",
+					),
 					OnActivate: func() tea.Msg {
 						err := libts.Logout(ctx)
 						if err != nil {
 							return errorMsg(err)
 						}
-						return successMsg("Logged out.")
+						return commandRanMsg{"Log Out", "tailscale logout", successMsg("Logged out.")}
 					},
 				},
+			)
+
+			submenuItems = append(submenuItems, m.settingSection("Services",
+				runSSHSetting,
+				runWebClientSetting,
+				advertiseConnectorSetting,
+			)...)
+			submenuItems = append(submenuItems, m.settingSection("Updates",
+				updateCheckSetting,
+				autoUpdateSetting,
+			)...)
+			submenuItems = append(submenuItems, m.settingSection("Advanced",
+				hostnameSetting,
+				operatorSetting,
+				postureCheckingSetting,
+			)...)
+			// Only shown on the OSes the settings apply to.
+			submenuItems = append(submenuItems, m.settingSection("Advanced - Linux",
+				netfilterModeSetting,
+				statefulFilteringSetting,
+				snatSubnetRoutesSetting,
+			)...)
+			submenuItems = append(submenuItems, m.settingSection("Advanced - Windows",
+				unattendedSetting,
+			)...)
+
+			m.settings.AdditionalLabel = ""
+			if pending := len(m.pendingEdits()); pending > 0 {
+				m.settings.AdditionalLabel = fmt.Sprintf("%d pending", pending)
+			}
+			m.settings.Submenu.SetItems(submenuItems)
+		}
+
+		// Update the presets submenu.
+		{
+			m.presetInput.OnSubmit = func(name string) tea.Msg {
+				return savePresetMsg(name)
+			}
+
+			submenuItems := []ui.SubmenuItem{
+				&ui.TitleSubmenuItem{Label: "Save Current Settings"},
+				m.presetInput,
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: "Apply"},
+			}
+			if len(m.config.Presets) == 0 {
+				submenuItems = append(submenuItems, &ui.LabeledSubmenuItem{
+					Label: "No presets yet",
+					IsDim: true,
+				})
+			}
+
+			names := make([]string, 0, len(m.config.Presets))
+			for name := range m.config.Presets {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+
+			for _, name := range names {
+				var additionalLabel string
+				changes, err := presetChanges(m.config.Presets[name], &m.state)
+				switch {
+				case err != nil:
+					additionalLabel = "Unavailable"
+				case len(changes) == 0:
+					additionalLabel = "Current"
+				case len(changes) == 1:
+					additionalLabel = "1 change"
+				default:
+					additionalLabel = fmt.Sprintf("%d changes", len(changes))
+				}
+
+				submenuItems = append(submenuItems, &ui.LabeledSubmenuItem{
+					Label:           name,
+					AdditionalLabel: additionalLabel,
+					OnActivate: func() tea.Msg {
+						return applyPresetMsg(name)
+					},
+					IsDim: err != nil,
+				})
 			}
 
-			// On Linux, show the advanced Linux settings.
-			if runtime.GOOS == "linux" {
-				var netfilterMode string
-				switch m.state.Prefs.NetfilterMode {
-				case preftype.NetfilterOn:
-					netfilterMode = "On"
-				case preftype.NetfilterNoDivert:
-					netfilterMode = "No Divert"
-				case preftype.NetfilterOff:
-					netfilterMode = "Off"
+			m.presets.Submenu.SetItems(submenuItems)
+		}
+
+		// Update the WhoIs submenu.
+		{
+			submenuItems := []ui.SubmenuItem{
+				m.whoisInput,
+			}
+
+			if m.whoisResult != nil {
+				submenuItems = append(submenuItems, whoisSubmenuItems(m.whoisResult)...)
+			} else {
+				submenuItems = append(submenuItems,
+					&ui.SpacerSubmenuItem{},
+					&ui.TitleSubmenuItem{Label: "Enter a tailnet address to see who owns it."},
+				)
+			}
+
+			m.whois.Submenu.SetItems(submenuItems)
+		}
+
+		// Update the DNS submenu.
+		{
+			magicDNS := "Disabled"
+			var magicDNSSuffix string
+			if m.state.Tailnet != nil {
+				if m.state.Tailnet.MagicDNSEnabled {
+					magicDNS = "Enabled"
 				}
+				magicDNSSuffix = m.state.Tailnet.MagicDNSSuffix
+			}
+
+			queryType := m.dnsQueryType
+			m.dnsInput.OnSubmit = func(name string) tea.Msg {
+				return makeQueryDNS(strings.TrimSpace(name), queryType)()
+			}
+
+			submenuItems := []ui.SubmenuItem{
+				&ui.TitleSubmenuItem{Label: "Status"},
+				&ui.LabeledSubmenuItem{Label: "MagicDNS", AdditionalLabel: magicDNS},
+				&ui.LabeledSubmenuItem{Label: "Use DNS Settings", AdditionalLabel: useDNSSettingsSetting.get(m.state.Prefs)},
+			}
+			if magicDNSSuffix != "" {
+				submenuItems = append(submenuItems,
+					copyableSubmenuItem(magicDNSSuffix, "Suffix", "Copied MagicDNS suffix to clipboard."),
+				)
+			}
 
-				noStatefulFiltering, _ := m.state.Prefs.NoStatefulFiltering.Get()
+			submenuItems = append(submenuItems,
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: "Query"},
+				m.dnsInput,
+				ui.NewSettingsSubmenuItem("Record Type",
+					libts.DNSQueryTypes,
+					m.dnsQueryType,
+					func(newLabel string) tea.Msg {
+						return dnsQueryTypeMsg(newLabel)
+					},
+				),
+			)
+
+			if m.dnsResult != nil {
+				result := m.dnsResult.result
 
 				submenuItems = append(submenuItems,
 					&ui.SpacerSubmenuItem{},
-					&ui.TitleSubmenuItem{Label: "Advanced - Linux"},
-
-					ui.NewSettingsSubmenuItem("NetFilter Mode",
-						[]string{"On", "No Divert", "Off"},
-						netfilterMode,
-						func(newLabel string) tea.Msg {
-							var netfilterMode preftype.NetfilterMode
-							switch newLabel {
-							case "On":
-								netfilterMode = preftype.NetfilterOn
-							case "No Divert":
-								netfilterMode = preftype.NetfilterNoDivert
-							case "Off":
-								netfilterMode = preftype.NetfilterOff
-							}
+					&ui.TitleSubmenuItem{Label: fmt.Sprintf("%s %s: %s", m.dnsResult.queryType, m.dnsResult.name, result.ResponseCode)},
+				)
+				if len(result.Answers) == 0 {
+					submenuItems = append(submenuItems, &ui.TitleSubmenuItem{Label: "No answers."})
+				}
+				for _, answer := range result.Answers {
+					submenuItems = append(submenuItems, copyableSubmenuItem(answer, "", "Copied answer to clipboard."))
+				}
 
-							return editPrefs(&ipn.MaskedPrefs{
-								Prefs: ipn.Prefs{
-									NetfilterMode: netfilterMode,
-								},
-								NetfilterModeSet: true,
-							})
-						},
-					),
+				submenuItems = append(submenuItems,
+					&ui.SpacerSubmenuItem{},
+					&ui.TitleSubmenuItem{Label: "Answered By"},
+				)
+				if len(result.Resolvers) == 0 {
+					submenuItems = append(submenuItems, &ui.TitleSubmenuItem{Label: "Tailscale (MagicDNS)"})
+				}
+				for _, resolver := range result.Resolvers {
+					submenuItems = append(submenuItems, copyableSubmenuItem(resolver.Addr, "", "Copied resolver to clipboard."))
+				}
+			}
 
-					ui.NewYesNoSettingsSubmenuItem("Enable Stateful Filtering",
-						!noStatefulFiltering,
-						func(newValue bool) tea.Msg {
-							return editPrefs(&ipn.MaskedPrefs{
-								Prefs: ipn.Prefs{
-									NoStatefulFiltering: opt.NewBool(!newValue),
-								},
-								NoStatefulFilteringSet: true,
-							})
-						},
+			m.dns.Submenu.SetItems(submenuItems)
+		}
+
+		// Update the bandwidth submenu.
+		{
+			submenuItems := []ui.SubmenuItem{
+				&ui.TitleSubmenuItem{Label: fmt.Sprintf("Total: ▼ %s  ▲ %s",
+					ui.FormatRate(m.traffic.totalRxRate),
+					ui.FormatRate(m.traffic.totalTxRate),
+				)},
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: "Top Talkers"},
+			}
+
+			if len(m.traffic.rates) == 0 {
+				submenuItems = append(submenuItems, &ui.TitleSubmenuItem{Label: "No peers."})
+			}
+			for i, rate := range m.traffic.rates {
+				if i >= maxTopTalkers {
+					break
+				}
+
+				peer := rate.peer
+				submenuItems = append(submenuItems, &ui.LabeledSubmenuItem{
+					Label: libts.PeerName(peer),
+					AdditionalLabel: fmt.Sprintf("▼ %s ▲ %s",
+						ui.FormatRate(rate.rxRate),
+						ui.FormatRate(rate.txRate),
 					),
+					OnActivate: func() tea.Msg {
+						if len(peer.TailscaleIPs) == 0 {
+							return nil
+						}
+						err := clipboard.WriteString(peer.TailscaleIPs[0].String())
+						if err != nil {
+							return errorMsg(err)
+						}
+						return successMsg("Copied peer address to clipboard.")
+					},
+					IsDim: !peer.Online,
+				})
+			}
+
+			m.bandwidth.AdditionalLabel = ui.FormatRate(m.traffic.totalRxRate + m.traffic.totalTxRate)
+			m.bandwidth.Submenu.SetItems(submenuItems)
+		}
+
+		// Update the config submenu.
+		{
+			fileStatus := "Loaded"
+			if !m.configFound {
+				fileStatus = "Not found, using defaults"
+			}
+
+			submenuItems := []ui.SubmenuItem{
+				&ui.TitleSubmenuItem{Label: "File - " + fileStatus},
+				copyableSubmenuItem(m.configPath, "", "Copied config file path to clipboard."),
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: "Effective Settings"},
+			}
+			for _, entry := range m.config.Entries() {
+				submenuItems = append(submenuItems, &ui.LabeledSubmenuItem{
+					Label:           entry.Key,
+					AdditionalLabel: entry.Value,
+				})
+			}
+
+			submenuItems = append(submenuItems,
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: "Keys"},
+			)
+			for _, binding := range m.keys.bindings {
+				submenuItems = append(submenuItems, &ui.LabeledSubmenuItem{
+					Label:           "keys." + string(binding.action),
+					AdditionalLabel: m.keys.describe(binding.action),
+				})
+			}
+
+			m.configInfo.Submenu.SetItems(submenuItems)
+		}
+
+		// Update the all preferences submenu.
+		{
+			prefs := m.state.Prefs
+			fields := libts.PrefsFields(prefs)
+
+			submenuItems := []ui.SubmenuItem{
+				&ui.LabeledSubmenuItem{
+					Label:   "[Copy as JSON]",
+					Variant: ui.SubmenuItemVariantAccent,
+					OnActivate: func() tea.Msg {
+						json, err := libts.RedactedPrefsJSON(prefs)
+						if err != nil {
+							return errorMsg(err)
+						}
+						err = clipboard.WriteString(json)
+						if err != nil {
+							return errorMsg(err)
+						}
+						return successMsg("Copied preferences to clipboard, with private keys left out.")
+					},
+				},
+			}
+
+			fieldItem := func(field libts.PrefsField) *ui.LabeledSubmenuItem {
+				return &ui.LabeledSubmenuItem{
+					Label:           field.Name,
+					AdditionalLabel: field.Value,
+					OnActivate: func() tea.Msg {
+						err := clipboard.WriteString(field.Name + " = " + field.Value)
+						if err != nil {
+							return errorMsg(err)
+						}
+						return successMsg("Copied preference to clipboard.")
+					},
+				}
+			}
+
+			// List what's been changed first, since that's usually what matters.
+			var changed, unchanged []ui.SubmenuItem
+			for _, field := range fields {
+				item := fieldItem(field)
+				if field.IsDefault {
+					item.IsDim = true
+					unchanged = append(unchanged, item)
+				} else {
+					item.Variant = ui.SubmenuItemVariantAccent
+					changed = append(changed, item)
+				}
+			}
+
+			submenuItems = append(submenuItems,
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: fmt.Sprintf("Changed from Defaults (%d)", len(changed))},
+			)
+			submenuItems = append(submenuItems, changed...)
+
+			if persistFields := libts.PersistFields(prefs); len(persistFields) > 0 {
+				submenuItems = append(submenuItems,
+					&ui.SpacerSubmenuItem{},
+					&ui.TitleSubmenuItem{Label: "Login - Private Keys Hidden"},
 				)
+				for _, field := range persistFields {
+					submenuItems = append(submenuItems, fieldItem(field))
+				}
 			}
 
-			m.settings.Submenu.SetItems(submenuItems)
+			submenuItems = append(submenuItems,
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: fmt.Sprintf("Defaults (%d)", len(unchanged))},
+			)
+			submenuItems = append(submenuItems, unchanged...)
+
+			m.allPrefs.AdditionalLabel = fmt.Sprintf("%d changed", len(changed))
+			m.allPrefs.Submenu.SetItems(submenuItems)
+		}
+
+		// Update the history submenu.
+		{
+			undoLabel := "Nothing to undo"
+			if change := m.lastUndoableChange(); change != nil {
+				undoLabel = "[Undo " + change.label + "]"
+			}
+
+			submenuItems := []ui.SubmenuItem{
+				&ui.LabeledSubmenuItem{
+					Label:           undoLabel,
+					AdditionalLabel: m.keys.hint(actionUndo),
+					Variant:         ui.SubmenuItemVariantAccent,
+					OnActivate: func() tea.Msg {
+						return undoMsg{}
+					},
+				},
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: "Recent Changes"},
+			}
+			if len(m.history) == 0 {
+				submenuItems = append(submenuItems, &ui.LabeledSubmenuItem{
+					Label: "No changes yet",
+					IsDim: true,
+				})
+			}
+			// Newest first.
+			for i := len(m.history) - 1; i >= 0; i-- {
+				change := m.history[i]
+				additionalLabel := change.time.Format(time.TimeOnly)
+				if change.undone {
+					additionalLabel = "undone " + additionalLabel
+				} else if change.undoing {
+					additionalLabel = "undoing " + additionalLabel
+				}
+				submenuItems = append(submenuItems, &ui.LabeledSubmenuItem{
+					Label:           change.label,
+					AdditionalLabel: additionalLabel,
+					IsDim:           change.undone,
+				})
+			}
+
+			m.historyLog.Submenu.SetItems(submenuItems)
+		}
+
+		// Update the commands submenu.
+		{
+			commands := m.commands
+			submenuItems := []ui.SubmenuItem{
+				&ui.LabeledSubmenuItem{
+					Label:   "[Export as Shell Script]",
+					Variant: ui.SubmenuItemVariantAccent,
+					OnActivate: func() tea.Msg {
+						if len(commands) == 0 {
+							return tipMsg("There are no commands to export yet.")
+						}
+						return exportCommands(commands)()
+					},
+				},
+				&ui.LabeledSubmenuItem{
+					Label: "[Copy as Shell Script]",
+					OnActivate: func() tea.Msg {
+						err := clipboard.WriteString(commandScript(commands))
+						if err != nil {
+							return errorMsg(err)
+						}
+						return successMsg("Copied commands to clipboard.")
+					},
+				},
+				&ui.SpacerSubmenuItem{},
+				&ui.TitleSubmenuItem{Label: "Equivalent Commands"},
+			}
+			if len(commands) == 0 {
+				submenuItems = append(submenuItems, &ui.LabeledSubmenuItem{
+					Label: "No changes yet",
+					IsDim: true,
+				})
+			}
+			// Newest first.
+			for i := len(commands) - 1; i >= 0; i-- {
+				submenuItems = append(submenuItems, copyableSubmenuItem(
+					commands[i].command,
+					commands[i].time.Format(time.TimeOnly),
+					"Copied command to clipboard.",
+				))
+			}
+
+			m.commandLog.Submenu.SetItems(submenuItems)
 		}
 
 		// Make sure the menu items are visible.
@@ -345,6 +715,14 @@ func (m *model) updateMenus() {
 			m.deviceInfo,
 			m.exitNodes,
 			m.settings,
+			m.presets,
+			m.whois,
+			m.dns,
+			m.bandwidth,
+			m.configInfo,
+			m.allPrefs,
+			m.historyLog,
+			m.commandLog,
 		})
 	} else {
 		// Hide the menu items if not connected.
@@ -352,3 +730,180 @@ func (m *model) updateMenus() {
 		m.menu.SetItems([]*ui.AppmenuItem{})
 	}
 }
+
+// Make a titled section of setting items, leaving out settings that don't apply to this OS.
+// Returns nothing if none of them apply.
+func (m *model) settingSection(title string, settings ...*setting) []ui.SubmenuItem {
+	var items []ui.SubmenuItem
+	for _, s := range settings {
+		if s.isSupported() {
+			items = append(items, m.settingSubmenuItem(s))
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return append([]ui.SubmenuItem{
+		&ui.SpacerSubmenuItem{},
+		&ui.TitleSubmenuItem{Label: title},
+	}, items...)
+}
+
+// Make a submenu item that cycles through the values of a setting and applies the new value.
+// Text settings get a text field instead.
+func (m *model) settingSubmenuItem(s *setting) ui.SubmenuItem {
+	if s.options == nil {
+		return m.textSettingSubmenuItem(s)
+	}
+
+	prefs := m.state.Prefs
+
+	// In staged edit mode, changes are collected and confirmed when they're applied.
+	if m.staging {
+		value := s.get(prefs)
+		pending := m.pendingEdit(s.name)
+		if pending != nil {
+			value = pending.value
+		}
+
+		item := ui.NewSettingsSubmenuItem(s.label, s.options, value, func(newValue string) tea.Msg {
+			return stageEditMsg(stageSetting(s, prefs, newValue))
+		})
+		item.IsPending = pending != nil
+		return item
+	}
+
+	// Changes being applied are shown right away, with a spinner until tailscaled is done.
+	value := s.get(prefs)
+	if edit := m.inFlight[s.name]; edit != nil {
+		value = edit.value
+	}
+
+	item := ui.NewSettingsSubmenuItem(s.label, s.options, value, func(newValue string) tea.Msg {
+		return inFlightMsg{
+			key:   s.name,
+			value: newValue,
+			cmd: func() tea.Msg {
+				return editPrefs(s.label+": "+newValue, s.edit(prefs, newValue))
+			},
+		}
+	})
+	item.Confirm = m.settingConfirm(s)
+	item.IsInFlight = m.runningEdit(s.name) != nil
+	return item
+}
+
+// Make a text field for a text setting that applies the new value when submitted. The field
+// is kept across menu updates so typing isn't interrupted, and shows the current value
+// when empty.
+func (m *model) textSettingSubmenuItem(s *setting) *ui.InputSubmenuItem {
+	item := m.settingInputs[s.name]
+	if item == nil {
+		item = &ui.InputSubmenuItem{Label: s.label, ClearOnSubmit: true}
+		m.settingInputs[s.name] = item
+	}
+
+	prefs := m.state.Prefs
+	item.Placeholder = s.display(s.get(prefs))
+	if pending := m.pendingEdit(s.name); m.staging && pending != nil {
+		item.Placeholder = "● " + pending.value
+	}
+
+	staging := m.staging
+	item.OnSubmit = func(input string) tea.Msg {
+		value, _ := s.parseValue(input)
+		// An empty field is easy to submit by accident, so it doesn't clear the setting.
+		if value == "" {
+			return tipMsg(fmt.Sprintf("Type a new %s first. To clear it, run: tsui settings set %s \"\"", s.label, s.name))
+		}
+		if staging {
+			return stageEditMsg(stageSetting(s, prefs, value))
+		}
+		return editPrefs(s.label+": "+s.display(value), s.edit(prefs, value))
+	}
+	return item
+}
+
+// Make a submenu item that copies its label to the clipboard when activated.
+func copyableSubmenuItem(label string, additionalLabel string, successText string) *ui.LabeledSubmenuItem {
+	return &ui.LabeledSubmenuItem{
+		Label:           label,
+		AdditionalLabel: additionalLabel,
+		OnActivate: func() tea.Msg {
+			err := clipboard.WriteString(label)
+			if err != nil {
+				return errorMsg(err)
+			}
+			return successMsg(successText)
+		},
+	}
+}
+
+// Build the submenu items describing the result of a WhoIs lookup.
+func whoisSubmenuItems(whois *apitype.WhoIsResponse) []ui.SubmenuItem {
+	var items []ui.SubmenuItem
+
+	if whois.Node != nil {
+		nodeName := whois.Node.ComputedName
+		if nodeName == "" {
+			nodeName = strings.TrimSuffix(whois.Node.Name, ".")
+		}
+
+		items = append(items,
+			&ui.SpacerSubmenuItem{},
+			&ui.TitleSubmenuItem{Label: "Node"},
+			copyableSubmenuItem(nodeName, "", "Copied node name to clipboard."),
+			copyableSubmenuItem(string(whois.Node.StableID), "ID", "Copied node ID to clipboard."),
+		)
+		for _, addr := range whois.Node.Addresses {
+			items = append(items, copyableSubmenuItem(addr.Addr().String(), "", "Copied address to clipboard."))
+		}
+	}
+
+	if whois.UserProfile != nil {
+		items = append(items,
+			&ui.SpacerSubmenuItem{},
+			&ui.TitleSubmenuItem{Label: "User"},
+			copyableSubmenuItem(whois.UserProfile.LoginName, "Login", "Copied login name to clipboard."),
+			copyableSubmenuItem(whois.UserProfile.DisplayName, "Name", "Copied display name to clipboard."),
+		)
+	}
+
+	if whois.Node != nil && len(whois.Node.Tags) > 0 {
+		items = append(items,
+			&ui.SpacerSubmenuItem{},
+			&ui.TitleSubmenuItem{Label: "Tags"},
+		)
+		for _, tag := range whois.Node.Tags {
+			items = append(items, copyableSubmenuItem(tag, "", "Copied tag to clipboard."))
+		}
+	}
+
+	items = append(items,
+		&ui.SpacerSubmenuItem{},
+		&ui.TitleSubmenuItem{Label: "Capabilities"},
+	)
+	if len(whois.CapMap) == 0 {
+		items = append(items, &ui.TitleSubmenuItem{Label: "None granted."})
+	} else {
+		caps := make([]string, 0, len(whois.CapMap))
+		for capability := range whois.CapMap {
+			caps = append(caps, string(capability))
+		}
+		slices.Sort(caps)
+
+		for _, capability := range caps {
+			var additionalLabel string
+			switch values := whois.CapMap[tailcfg.PeerCapability(capability)]; len(values) {
+			case 0:
+			case 1:
+				additionalLabel = "1 value"
+			default:
+				additionalLabel = fmt.Sprintf("%d values", len(values))
+			}
+			items = append(items, copyableSubmenuItem(capability, additionalLabel, "Copied capability to clipboard."))
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/neuralinkcorp/tsui/config"
+	"github.com/neuralinkcorp/tsui/libts"
+	"github.com/neuralinkcorp/tsui/ui"
+	"tailscale.com/ipn"
+)
+
+// Message asking to preview the named preset's changes before applying them.
+type applyPresetMsg string
+
+// Message asking to save the current preferences as a preset with the given name.
+type savePresetMsg string
+
+// Message reporting that a preset was saved to the config file.
+type presetSavedMsg struct {
+	name string
+}
+
+// Message reporting that saving a preset to the config file failed.
+type presetSaveFailedMsg struct {
+	name string
+	err  error
+}
+
+// One preference a preset would change.
+type presetChange struct {
+	// Label of the preference, like "Use Subnet Routes".
+	label string
+	// Current and new values, for display.
+	from string
+	to   string
+	// Edit that makes the change.
+	edit *ipn.MaskedPrefs
+}
+
+// Make a preset from the current preferences.
+func presetFromState(state *libts.State) config.Preset {
+	prefs := state.Prefs
+	yes := func(s *setting) *bool {
+		value := s.get(prefs) == "Yes"
+		return &value
+	}
+
+	routes := make([]string, len(prefs.AdvertiseRoutes))
+	for i, route := range prefs.AdvertiseRoutes {
+		routes[i] = route.String()
+	}
+
+	exitNode := state.CurrentExitNodeName
+	preset := config.Preset{
+		AllowIncoming:      yes(allowIncomingSetting),
+		UseSubnetRoutes:    yes(useSubnetRoutesSetting),
+		UseDNSSettings:     yes(useDNSSettingsSetting),
+		LocalNetworkAccess: yes(localNetworkAccessSetting),
+		ExitNode:           &exitNode,
+		AdvertiseRoutes:    &routes,
+	}
+	if netfilterModeSetting.isSupported() {
+		// The config uses the setting's values without spaces, like "nodivert".
+		netfilterMode := strings.ToLower(strings.ReplaceAll(netfilterModeSetting.get(prefs), " ", ""))
+		preset.NetfilterMode = &netfilterMode
+	}
+	return preset
+}
+
+// Work out the changes needed to get from the current preferences to the preset. Fields
+// that already match, or that the preset leaves out, aren't included.
+func presetChanges(preset config.Preset, state *libts.State) ([]presetChange, error) {
+	prefs := state.Prefs
+	var changes []presetChange
+
+	addSetting := func(s *setting, value string) {
+		if !s.isSupported() {
+			return
+		}
+		current := s.get(prefs)
+		if current != value {
+			changes = append(changes, presetChange{s.label, current, value, s.edit(prefs, value)})
+		}
+	}
+	addYesNo := func(s *setting, value *bool) {
+		if value == nil {
+			return
+		}
+		if *value {
+			addSetting(s, "Yes")
+		} else {
+			addSetting(s, "No")
+		}
+	}
+
+	addYesNo(allowIncomingSetting, preset.AllowIncoming)
+	addYesNo(useSubnetRoutesSetting, preset.UseSubnetRoutes)
+	addYesNo(useDNSSettingsSetting, preset.UseDNSSettings)
+	addYesNo(localNetworkAccessSetting, preset.LocalNetworkAccess)
+
+	if preset.ExitNode != nil {
+		current := state.CurrentExitNodeName
+		if current == "" {
+			current = "None"
+		}
+
+		if *preset.ExitNode == "" {
+			if state.CurrentExitNode != nil {
+				changes = append(changes, presetChange{"Exit Node", current, "None", libts.ExitNodeEdit(nil)})
+			}
+		} else {
+			peer, err := findExitNode(state, *preset.ExitNode)
+			if err != nil {
+				return nil, err
+			}
+			if state.CurrentExitNode == nil || *state.CurrentExitNode != peer.ID {
+				changes = append(changes, presetChange{"Exit Node", current, libts.PeerName(peer), libts.ExitNodeEdit(peer)})
+			}
+		}
+	}
+
+	if preset.AdvertiseRoutes != nil {
+		routes := make([]netip.Prefix, len(*preset.AdvertiseRoutes))
+		for i, route := range *preset.AdvertiseRoutes {
+			prefix, err := netip.ParsePrefix(route)
+			if err != nil {
+				return nil, err
+			}
+			routes[i] = prefix.Masked()
+		}
+
+		current, wanted := formatRoutes(prefs.AdvertiseRoutes), formatRoutes(routes)
+		if current != wanted {
+			changes = append(changes, presetChange{"Advertised Routes", current, wanted, &ipn.MaskedPrefs{
+				Prefs:              ipn.Prefs{AdvertiseRoutes: routes},
+				AdvertiseRoutesSet: true,
+			}})
+		}
+	}
+
+	if preset.NetfilterMode != nil && netfilterModeSetting.isSupported() {
+		value, err := netfilterModeSetting.parseValue(*preset.NetfilterMode)
+		if err != nil {
+			return nil, err
+		}
+		addSetting(netfilterModeSetting, value)
+	}
+
+	return changes, nil
+}
+
+// Format routes for display, sorted so the order they were added in doesn't matter.
+func formatRoutes(routes []netip.Prefix) string {
+	if len(routes) == 0 {
+		return "None"
+	}
+	strs := make([]string, len(routes))
+	for i, route := range routes {
+		strs[i] = route.String()
+	}
+	slices.Sort(strs)
+	return strings.Join(strs, ", ")
+}
+
+// Make the dialog previewing a preset's changes, which applies them all in one edit if
+// confirmed. Returns a tip instead if the preset is already applied. Must only be called
+// from Update.
+func (m *model) applyPreset(name string) tea.Msg {
+	changes, err := presetChanges(m.config.Presets[name], &m.state)
+	if err != nil {
+		return errorMsg(fmt.Errorf("preset %s: %w", name, err))
+	}
+	if len(changes) == 0 {
+		return tipMsg(fmt.Sprintf("Preset %s is already applied.", name))
+	}
+
+	lines := make([]string, len(changes))
+	edits := make([]*ipn.MaskedPrefs, len(changes))
+	for i, change := range changes {
+		lines[i] = fmt.Sprintf("%s: %s → %s", change.label, change.from, change.to)
+		edits[i] = change.edit
+	}
+
+	// Always preview, since a preset can change several things at once.
+	confirm := m.makeConfirm(true,
+		fmt.Sprintf("Apply preset %s?", name),
+		strings.Join(lines, "\n"),
+		"Apply",
+	)
+	edit := libts.MergeEdits(edits...)
+	confirm.OnConfirm = func() tea.Msg {
+		return editPrefs("Preset: "+name, edit)
+	}
+	return ui.ConfirmMsg(confirm)
+}
+
+// Create a command that saves the current preferences to the config file as a new preset.
+// The preset is added to the menu right away, so saving the same name again before the
+// file is written fails instead of adding it twice. Must only be called from Update.
+func (m *model) savePreset(name string) tea.Cmd {
+	name = strings.TrimSpace(name)
+	err := config.ValidatePresetName(name)
+	if err != nil {
+		return func() tea.Msg {
+			return errorMsg(err)
+		}
+	}
+	if _, ok := m.config.Presets[name]; ok {
+		err := fmt.Errorf("a preset named %s already exists; edit or remove it in %s", name, m.configPath)
+		return func() tea.Msg {
+			return errorMsg(err)
+		}
+	}
+
+	preset := presetFromState(&m.state)
+	if m.config.Presets == nil {
+		m.config.Presets = make(map[string]config.Preset)
+	}
+	m.config.Presets[name] = preset
+	m.updateMenus()
+
+	path := m.configPath
+	return func() tea.Msg {
+		err := config.AppendPreset(path, name, preset)
+		if err != nil {
+			return presetSaveFailedMsg{name, err}
+		}
+		return presetSavedMsg{name}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/neuralinkcorp/tsui/libts"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// Cumulative traffic counters for a single peer.
+type peerAccount struct {
+	name string
+	// Raw counters from the last sample, used to detect resets.
+	lastRxBytes int64
+	lastTxBytes int64
+	// Bytes transferred since recording started, including traffic from before any resets.
+	rxBytes int64
+	txBytes int64
+}
+
+// Accumulates per-peer byte counters across samples. The counters in ipnstate reset to
+// zero when tailscaled restarts, so they can't be used as running totals directly.
+type trafficAccount struct {
+	peers map[tailcfg.StableNodeID]*peerAccount
+	// Sum of all peers' cumulative counters, including peers that have since disappeared.
+	rxBytes int64
+	txBytes int64
+	// Run of tailscaled the last sample came from, from libts.RestartWatcher.
+	run int
+	// Whether tailscaled restarted since recording started, so everything its counters
+	// hold was transferred while recording.
+	restarted bool
+}
+
+// Add a new sample of the peers' counters, taken from the given run of tailscaled, to the
+// running totals.
+func (a *trafficAccount) add(peers []*ipnstate.PeerStatus, run int) {
+	if a.peers == nil {
+		a.peers = make(map[tailcfg.StableNodeID]*peerAccount)
+	} else if run != a.run {
+		// tailscaled restarted, so every counter started over from zero.
+		a.restarted = true
+		for _, account := range a.peers {
+			account.lastRxBytes = 0
+			account.lastTxBytes = 0
+		}
+	}
+	a.run = run
+
+	for _, peer := range peers {
+		account, ok := a.peers[peer.ID]
+		if !ok {
+			account = &peerAccount{}
+			// Counters from before recording started aren't counted.
+			if !a.restarted {
+				account.lastRxBytes = peer.RxBytes
+				account.lastTxBytes = peer.TxBytes
+			}
+			a.peers[peer.ID] = account
+		}
+		account.name = libts.PeerName(peer)
+
+		rxDelta := peer.RxBytes - account.lastRxBytes
+		txDelta := peer.TxBytes - account.lastTxBytes
+		// If a counter went backwards, it was reset, so everything it counted is new.
+		if rxDelta < 0 {
+			rxDelta = peer.RxBytes
+		}
+		if txDelta < 0 {
+			txDelta = peer.TxBytes
+		}
+
+		account.lastRxBytes = peer.RxBytes
+		account.lastTxBytes = peer.TxBytes
+		account.rxBytes += rxDelta
+		account.txBytes += txDelta
+		a.rxBytes += rxDelta
+		a.txBytes += txDelta
+	}
+}
+
+// Peer IDs in the account, sorted by peer name.
+func (a *trafficAccount) sortedIDs() []tailcfg.StableNodeID {
+	ids := make([]tailcfg.StableNodeID, 0, len(a.peers))
+	for id := range a.peers {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(x, y tailcfg.StableNodeID) int {
+		if c := strings.Compare(a.peers[x].name, a.peers[y].name); c != 0 {
+			return c
+		}
+		return strings.Compare(string(x), string(y))
+	})
+	return ids
+}
+
+// One line of the JSON Lines output.
+type recordSample struct {
+	Time     time.Time          `json:"time"`
+	ExitNode string             `json:"exitNode"`
+	RxBytes  int64              `json:"rxBytes"`
+	TxBytes  int64              `json:"txBytes"`
+	Peers    []recordPeerSample `json:"peers"`
+}
+
+type recordPeerSample struct {
+	ID      tailcfg.StableNodeID `json:"id"`
+	Name    string               `json:"name"`
+	RxBytes int64                `json:"rxBytes"`
+	TxBytes int64                `json:"txBytes"`
+}
+
+// Write a sample as one JSON object per line.
+func writeRecordJSON(w io.Writer, now time.Time, exitNode string, account *trafficAccount) error {
+	sample := recordSample{
+		Time:     now.UTC(),
+		ExitNode: exitNode,
+		RxBytes:  account.rxBytes,
+		TxBytes:  account.txBytes,
+		Peers:    make([]recordPeerSample, 0, len(account.peers)),
+	}
+	for _, id := range account.sortedIDs() {
+		peer := account.peers[id]
+		sample.Peers = append(sample.Peers, recordPeerSample{
+			ID:      id,
+			Name:    peer.name,
+			RxBytes: peer.rxBytes,
+			TxBytes: peer.txBytes,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(sample)
+}
+
+// Header of the CSV output. Each sample is written as one row per peer plus a "total" row.
+var recordCSVHeader = []string{"time", "exit_node", "peer_id", "peer", "rx_bytes", "tx_bytes"}
+
+// Write a sample as CSV rows.
+func writeRecordCSV(w *csv.Writer, now time.Time, exitNode string, account *trafficAccount) error {
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	err := w.Write([]string{
+		timestamp, exitNode, "", "total",
+		strconv.FormatInt(account.rxBytes, 10),
+		strconv.FormatInt(account.txBytes, 10),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range account.sortedIDs() {
+		peer := account.peers[id]
+		err := w.Write([]string{
+			timestamp, exitNode, string(id), peer.name,
+			strconv.FormatInt(peer.rxBytes, 10),
+			strconv.FormatInt(peer.txBytes, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// Entrypoint for `tsui record`: periodically append bandwidth samples to a file until interrupted.
+func runRecord(args []string) error {
+	flags := flag.NewFlagSet("tsui record", flag.ContinueOnError)
+	format := flags.String("format", "csv", "output format, csv or jsonl")
+	interval := flags.Duration("interval", 1*time.Minute, "time between samples")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: tsui record [flags] FILE")
+		fmt.Fprintln(flags.Output(), "\nAppend periodic bandwidth samples to FILE, with running totals that survive tailscaled restarts.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one output file")
+	}
+	if *format != "csv" && *format != "jsonl" {
+		return fmt.Errorf("unknown format %q, expected csv or jsonl", *format)
+	}
+	if *interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	path := flags.Arg(0)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(file)
+	if *format == "csv" && info.Size() == 0 {
+		csvWriter.Write(recordCSVHeader)
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Recording bandwidth to %s every %s. Press ctrl+c to stop.\n", path, *interval)
+
+	restarts := libts.WatchRestarts(ctx)
+	var account trafficAccount
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		// Only use samples that are known to come from a single run of tailscaled.
+		runBefore, upBefore := restarts.Run()
+		state, err := libts.GetState(ctx)
+		runAfter, upAfter := restarts.Run()
+		if err == nil && (!upBefore || !upAfter || runBefore != runAfter) {
+			err = errors.New("tailscaled is restarting")
+		}
+
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		if err != nil {
+			// Keep going; tailscaled may just be restarting.
+			fmt.Fprintln(os.Stderr, "Skipping sample:", err)
+		} else {
+			now := time.Now()
+			account.add(state.SortedPeers, runAfter)
+
+			if *format == "csv" {
+				err = writeRecordCSV(csvWriter, now, state.CurrentExitNodeName, &account)
+			} else {
+				err = writeRecordJSON(file, now, state.CurrentExitNodeName, &account)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
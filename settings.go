@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/opt"
+	"tailscale.com/types/preftype"
+)
+
+// A user-facing Tailscale preference with a fixed set of values, or free text. These
+// definitions are shared by the Settings submenu and the `tsui settings` command so they
+// always agree.
+type setting struct {
+	// Identifier used on the command line, such as "allow-incoming".
+	name string
+	// Label shown in the Settings submenu.
+	label string
+	// Possible values, in the order they're cycled through in the submenu. Nil for text
+	// settings, which take any value.
+	options []string
+	// Shown instead of an empty value of a text setting, like "None".
+	placeholder string
+	// If set, the setting only applies on these OSes.
+	goos []string
+	// Get the current value from the preferences. Returns one of the options, if there are any.
+	get func(prefs *ipn.Prefs) string
+	// Make the preference edit that changes the setting to value, which is one of the options
+	// if there are any.
+	// Takes the current preferences for settings that only change part of a field.
+	edit func(prefs *ipn.Prefs, value string) *ipn.MaskedPrefs
+	// If set, describes what could go wrong when changing to value, or returns empty if
+	// that change is safe. The Settings submenu asks for confirmation of risky changes.
+	risk func(value string) string
+}
+
+// Make a setting with "Yes" and "No" values.
+func newYesNoSetting(name string, label string, get func(prefs *ipn.Prefs) bool, edit func(newValue bool) *ipn.MaskedPrefs) *setting {
+	return &setting{
+		name:    name,
+		label:   label,
+		options: []string{"Yes", "No"},
+		get: func(prefs *ipn.Prefs) string {
+			if get(prefs) {
+				return "Yes"
+			}
+			return "No"
+		},
+		edit: func(_ *ipn.Prefs, value string) *ipn.MaskedPrefs {
+			return edit(value == "Yes")
+		},
+	}
+}
+
+// Returns true if the setting applies to the current OS.
+func (s *setting) isSupported() bool {
+	return len(s.goos) == 0 || slices.Contains(s.goos, runtime.GOOS)
+}
+
+// Format a value for display, using the placeholder for an empty text value.
+func (s *setting) display(value string) string {
+	if value == "" && s.placeholder != "" {
+		return s.placeholder
+	}
+	return value
+}
+
+// Make a setting that takes any text.
+func newTextSetting(name string, label string, placeholder string, get func(prefs *ipn.Prefs) string, edit func(newValue string) *ipn.MaskedPrefs) *setting {
+	return &setting{
+		name:        name,
+		label:       label,
+		placeholder: placeholder,
+		get:         get,
+		edit: func(_ *ipn.Prefs, value string) *ipn.MaskedPrefs {
+			return edit(value)
+		},
+	}
+}
+
+// Find the option matching user input, ignoring case, spaces and dashes. Yes/no settings
+// also accept true/false and on/off. Text settings accept anything, without surrounding
+// spaces.
+func (s *setting) parseValue(input string) (string, error) {
+	if s.options == nil {
+		return strings.TrimSpace(input), nil
+	}
+
+	normalize := func(str string) string {
+		str = strings.ToLower(str)
+		str = strings.ReplaceAll(str, " ", "")
+		str = strings.ReplaceAll(str, "-", "")
+		return str
+	}
+
+	normalizedInput := normalize(input)
+	if slices.Equal(s.options, []string{"Yes", "No"}) {
+		switch normalizedInput {
+		case "true", "on":
+			normalizedInput = "yes"
+		case "false", "off":
+			normalizedInput = "no"
+		}
+	}
+
+	for _, option := range s.options {
+		if normalize(option) == normalizedInput {
+			return option, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid value %q for %s, expected one of: %s", input, s.name, strings.Join(s.options, ", "))
+}
+
+var (
+	allowIncomingSetting = newYesNoSetting("allow-incoming", "Allow Incoming Connections",
+		func(prefs *ipn.Prefs) bool {
+			return !prefs.ShieldsUp
+		},
+		func(newValue bool) *ipn.MaskedPrefs {
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					ShieldsUp: !newValue,
+				},
+				ShieldsUpSet: true,
+			}
+		},
+	)
+
+	useSubnetRoutesSetting = newYesNoSetting("use-subnet-routes", "Use Subnet Routes",
+		func(prefs *ipn.Prefs) bool {
+			return prefs.RouteAll
+		},
+		func(newValue bool) *ipn.MaskedPrefs {
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					RouteAll: newValue,
+				},
+				RouteAllSet: true,
+			}
+		},
+	)
+
+	useDNSSettingsSetting = func() *setting {
+		s := newYesNoSetting("use-dns-settings", "Use DNS Settings",
+			func(prefs *ipn.Prefs) bool {
+				return prefs.CorpDNS
+			},
+			func(newValue bool) *ipn.MaskedPrefs {
+				return &ipn.MaskedPrefs{
+					Prefs: ipn.Prefs{
+						CorpDNS: newValue,
+					},
+					CorpDNSSet: true,
+				}
+			},
+		)
+		s.risk = func(value string) string {
+			if value == "No" {
+				return "This device will stop using the tailnet's DNS settings, so MagicDNS names and split DNS routes will no longer resolve."
+			}
+			return ""
+		}
+		return s
+	}()
+
+	localNetworkAccessSetting = newYesNoSetting("local-network-access", "Enable Local Network Access",
+		func(prefs *ipn.Prefs) bool {
+			return prefs.ExitNodeAllowLANAccess
+		},
+		func(newValue bool) *ipn.MaskedPrefs {
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					ExitNodeAllowLANAccess: newValue,
+				},
+				ExitNodeAllowLANAccessSet: true,
+			}
+		},
+	)
+
+	advertiseExitNodeSetting = &setting{
+		name:    "advertise-exit-node",
+		label:   "Advertise Exit Node",
+		options: []string{"Exit Node", "No"},
+		get: func(prefs *ipn.Prefs) string {
+			if prefs.AdvertisesExitNode() {
+				return "Exit Node"
+			}
+			return "No"
+		},
+		edit: func(prefs *ipn.Prefs, value string) *ipn.MaskedPrefs {
+			// Start from the current routes so advertised subnets are kept.
+			newPrefs := ipn.Prefs{
+				AdvertiseRoutes: slices.Clone(prefs.AdvertiseRoutes),
+			}
+			newPrefs.SetAdvertiseExitNode(value == "Exit Node")
+			return &ipn.MaskedPrefs{
+				Prefs:              newPrefs,
+				AdvertiseRoutesSet: true,
+			}
+		},
+	}
+
+	netfilterModeSetting = &setting{
+		name:    "netfilter-mode",
+		label:   "NetFilter Mode",
+		options: []string{"On", "No Divert", "Off"},
+		goos:    []string{"linux"},
+		get: func(prefs *ipn.Prefs) string {
+			switch prefs.NetfilterMode {
+			case preftype.NetfilterNoDivert:
+				return "No Divert"
+			case preftype.NetfilterOff:
+				return "Off"
+			}
+			return "On"
+		},
+		edit: func(_ *ipn.Prefs, value string) *ipn.MaskedPrefs {
+			var netfilterMode preftype.NetfilterMode
+			switch value {
+			case "On":
+				netfilterMode = preftype.NetfilterOn
+			case "No Divert":
+				netfilterMode = preftype.NetfilterNoDivert
+			case "Off":
+				netfilterMode = preftype.NetfilterOff
+			}
+
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					NetfilterMode: netfilterMode,
+				},
+				NetfilterModeSet: true,
+			}
+		},
+		risk: func(value string) string {
+			if value == "Off" {
+				return "Tailscale will stop managing this device's firewall rules, which can block traffic on the tailnet until you configure them yourself."
+			}
+			return "Tailscale will rewrite this device's firewall rules, which can briefly interrupt connections."
+		},
+	}
+
+	statefulFilteringSetting = func() *setting {
+		s := newYesNoSetting("stateful-filtering", "Enable Stateful Filtering",
+			func(prefs *ipn.Prefs) bool {
+				noStatefulFiltering, _ := prefs.NoStatefulFiltering.Get()
+				return !noStatefulFiltering
+			},
+			func(newValue bool) *ipn.MaskedPrefs {
+				return &ipn.MaskedPrefs{
+					Prefs: ipn.Prefs{
+						NoStatefulFiltering: opt.NewBool(!newValue),
+					},
+					NoStatefulFilteringSet: true,
+				}
+			},
+		)
+		s.goos = []string{"linux"}
+		return s
+	}()
+
+	snatSubnetRoutesSetting = func() *setting {
+		s := newYesNoSetting("snat-subnet-routes", "SNAT Subnet Routes",
+			func(prefs *ipn.Prefs) bool {
+				return !prefs.NoSNAT
+			},
+			func(newValue bool) *ipn.MaskedPrefs {
+				return &ipn.MaskedPrefs{
+					Prefs: ipn.Prefs{
+						NoSNAT: !newValue,
+					},
+					NoSNATSet: true,
+				}
+			},
+		)
+		s.goos = []string{"linux"}
+		return s
+	}()
+
+	runSSHSetting = func() *setting {
+		s := newYesNoSetting("ssh", "Run Tailscale SSH Server",
+			func(prefs *ipn.Prefs) bool {
+				return prefs.RunSSH
+			},
+			func(newValue bool) *ipn.MaskedPrefs {
+				return &ipn.MaskedPrefs{
+					Prefs: ipn.Prefs{
+						RunSSH: newValue,
+					},
+					RunSSHSet: true,
+				}
+			},
+		)
+		s.goos = []string{"linux", "darwin"}
+		s.risk = func(value string) string {
+			if value == "No" {
+				return "Tailscale SSH sessions to this device will be closed, including the one you may be using right now."
+			}
+			return ""
+		}
+		return s
+	}()
+
+	runWebClientSetting = newYesNoSetting("webclient", "Run Web Interface",
+		func(prefs *ipn.Prefs) bool {
+			return prefs.RunWebClient
+		},
+		func(newValue bool) *ipn.MaskedPrefs {
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					RunWebClient: newValue,
+				},
+				RunWebClientSet: true,
+			}
+		},
+	)
+
+	advertiseConnectorSetting = newYesNoSetting("advertise-connector", "Advertise App Connector",
+		func(prefs *ipn.Prefs) bool {
+			return prefs.AppConnector.Advertise
+		},
+		func(newValue bool) *ipn.MaskedPrefs {
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					AppConnector: ipn.AppConnectorPrefs{
+						Advertise: newValue,
+					},
+				},
+				AppConnectorSet: true,
+			}
+		},
+	)
+
+	updateCheckSetting = newYesNoSetting("update-check", "Check for Updates",
+		func(prefs *ipn.Prefs) bool {
+			return prefs.AutoUpdate.Check
+		},
+		func(newValue bool) *ipn.MaskedPrefs {
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					AutoUpdate: ipn.AutoUpdatePrefs{
+						Check: newValue,
+					},
+				},
+				AutoUpdateSet: ipn.AutoUpdatePrefsMask{
+					CheckSet: true,
+				},
+			}
+		},
+	)
+
+	autoUpdateSetting = func() *setting {
+		s := newYesNoSetting("auto-update", "Install Updates Automatically",
+			func(prefs *ipn.Prefs) bool {
+				apply, _ := prefs.AutoUpdate.Apply.Get()
+				return apply
+			},
+			func(newValue bool) *ipn.MaskedPrefs {
+				return &ipn.MaskedPrefs{
+					Prefs: ipn.Prefs{
+						AutoUpdate: ipn.AutoUpdatePrefs{
+							Apply: opt.NewBool(newValue),
+						},
+					},
+					AutoUpdateSet: ipn.AutoUpdatePrefsMask{
+						ApplySet: true,
+					},
+				}
+			},
+		)
+		// Same platforms as `tailscale set --auto-update`.
+		s.goos = []string{"linux", "darwin", "windows"}
+		return s
+	}()
+
+	postureCheckingSetting = newYesNoSetting("posture-checking", "Allow Posture Checking",
+		func(prefs *ipn.Prefs) bool {
+			return prefs.PostureChecking
+		},
+		func(newValue bool) *ipn.MaskedPrefs {
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					PostureChecking: newValue,
+				},
+				PostureCheckingSet: true,
+			}
+		},
+	)
+
+	hostnameSetting = newTextSetting("hostname", "Hostname", "OS default",
+		func(prefs *ipn.Prefs) string {
+			return prefs.Hostname
+		},
+		func(newValue string) *ipn.MaskedPrefs {
+			return &ipn.MaskedPrefs{
+				Prefs: ipn.Prefs{
+					Hostname: newValue,
+				},
+				HostnameSet: true,
+			}
+		},
+	)
+
+	operatorSetting = func() *setting {
+		s := newTextSetting("operator", "Operator User", "None",
+			func(prefs *ipn.Prefs) string {
+				return prefs.OperatorUser
+			},
+			func(newValue string) *ipn.MaskedPrefs {
+				return &ipn.MaskedPrefs{
+					Prefs: ipn.Prefs{
+						OperatorUser: newValue,
+					},
+					OperatorUserSet: true,
+				}
+			},
+		)
+		// Only OSes where tailscaled can tell which user is connecting.
+		s.goos = []string{"linux", "darwin", "freebsd"}
+		return s
+	}()
+
+	unattendedSetting = func() *setting {
+		s := newYesNoSetting("unattended", "Run Unattended",
+			func(prefs *ipn.Prefs) bool {
+				return prefs.ForceDaemon
+			},
+			func(newValue bool) *ipn.MaskedPrefs {
+				return &ipn.MaskedPrefs{
+					Prefs: ipn.Prefs{
+						ForceDaemon: newValue,
+					},
+					ForceDaemonSet: true,
+				}
+			},
+		)
+		s.goos = []string{"windows"}
+		return s
+	}()
+)
+
+// All settings, in the order they're listed by `tsui settings list`.
+var allSettings = []*setting{
+	allowIncomingSetting,
+	useSubnetRoutesSetting,
+	useDNSSettingsSetting,
+	localNetworkAccessSetting,
+	advertiseExitNodeSetting,
+	runSSHSetting,
+	runWebClientSetting,
+	advertiseConnectorSetting,
+	updateCheckSetting,
+	autoUpdateSetting,
+	hostnameSetting,
+	operatorSetting,
+	postureCheckingSetting,
+	netfilterModeSetting,
+	statefulFilteringSetting,
+	snatSubnetRoutesSetting,
+	unattendedSetting,
+}
+
+// Find a setting by its command line name.
+func findSetting(name string) (*setting, error) {
+	for _, s := range allSettings {
+		if s.name == name {
+			if !s.isSupported() {
+				return nil, fmt.Errorf("setting %s is only supported on %s", name, strings.Join(s.goos, ", "))
+			}
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown setting %q; run `tsui settings list` to see all settings", name)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/neuralinkcorp/tsui/libts"
+	"github.com/neuralinkcorp/tsui/ui"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// A change held back in staged edit mode until the user applies all pending changes at once.
+type stagedEdit struct {
+	// Identifies what's being changed, so staging it again replaces this edit. The setting
+	// name or "exit-node".
+	key string
+	// Label of the preference, like "Use Subnet Routes".
+	label string
+	// New value, formatted for display.
+	value string
+	// Get the current value from the state, for display and to tell if the edit is a no-op.
+	current func(state *libts.State) string
+	// Edit that makes the change.
+	edit *ipn.MaskedPrefs
+	// What could go wrong with the change, or empty if it's safe.
+	risk string
+}
+
+// Message asking to stage an edit, or to drop the staged edit with the same key if it would
+// change nothing.
+type stageEditMsg *stagedEdit
+
+// Message turning staged edit mode on or off.
+type stagingModeMsg bool
+
+// Message reporting that the staged edits were applied.
+type stagedAppliedMsg prefsEditedMsg
+
+// Message asking to drop all staged edits.
+type stagedDiscardedMsg struct{}
+
+// Make the edit staging a setting change.
+func stageSetting(s *setting, prefs *ipn.Prefs, value string) *stagedEdit {
+	edit := &stagedEdit{
+		key:   s.name,
+		label: s.label,
+		value: s.display(value),
+		current: func(state *libts.State) string {
+			return s.display(s.get(state.Prefs))
+		},
+		edit: s.edit(prefs, value),
+	}
+	if s.risk != nil {
+		edit.risk = s.risk(value)
+	}
+	return edit
+}
+
+// Make the edit staging an exit node change. A nil peer clears the exit node.
+func stageExitNode(peer *ipnstate.PeerStatus) *stagedEdit {
+	value := "None"
+	if peer != nil {
+		value = libts.PeerName(peer)
+	}
+	return &stagedEdit{
+		key:     "exit-node",
+		label:   "Exit Node",
+		value:   value,
+		current: currentExitNodeLabel,
+		edit:    libts.ExitNodeEdit(peer),
+	}
+}
+
+// Get the name of the current exit node, or "None".
+func currentExitNodeLabel(state *libts.State) string {
+	if state.CurrentExitNodeName == "" {
+		return "None"
+	}
+	return state.CurrentExitNodeName
+}
+
+// Add or replace a staged edit. Edits back to the current value are dropped.
+func (m *model) stageEdit(edit *stagedEdit) {
+	for i, existing := range m.staged {
+		if existing.key == edit.key {
+			m.staged = append(m.staged[:i], m.staged[i+1:]...)
+			break
+		}
+	}
+	if edit.value != edit.current(&m.state) {
+		m.staged = append(m.staged, edit)
+	}
+}
+
+// Get the staged edits that would still change something, in the order they were staged.
+func (m *model) pendingEdits() []*stagedEdit {
+	var pending []*stagedEdit
+	for _, edit := range m.staged {
+		if edit.value != edit.current(&m.state) {
+			pending = append(pending, edit)
+		}
+	}
+	return pending
+}
+
+// Get the staged edit for key, or nil if nothing is pending for it.
+func (m *model) pendingEdit(key string) *stagedEdit {
+	for _, edit := range m.pendingEdits() {
+		if edit.key == key {
+			return edit
+		}
+	}
+	return nil
+}
+
+// Build the submenu items listing the pending edits, with buttons to apply or discard them.
+func (m *model) pendingSubmenuItems() []ui.SubmenuItem {
+	pending := m.pendingEdits()
+
+	items := []ui.SubmenuItem{
+		&ui.TitleSubmenuItem{Label: "Pending Changes"},
+	}
+	if len(pending) == 0 {
+		return append(items, &ui.LabeledSubmenuItem{
+			Label: "No pending changes",
+			IsDim: true,
+		})
+	}
+
+	labels := make([]string, len(pending))
+	edits := make([]*ipn.MaskedPrefs, len(pending))
+	var risks []string
+	for i, edit := range pending {
+		items = append(items, &ui.LabeledSubmenuItem{
+			Label:           edit.label,
+			AdditionalLabel: edit.current(&m.state) + " → " + edit.value,
+		})
+		labels[i] = edit.label + ": " + edit.value
+		edits[i] = edit.edit
+		if edit.risk != "" {
+			risks = append(risks, edit.risk)
+		}
+	}
+
+	applyLabel := "[Apply 1 Change]"
+	if len(pending) > 1 {
+		applyLabel = fmt.Sprintf("[Apply %d Changes]", len(pending))
+	}
+
+	edit := libts.MergeEdits(edits...)
+	label := strings.Join(labels, ", ")
+	return append(items,
+		&ui.LabeledSubmenuItem{
+			Label:   applyLabel,
+			Variant: ui.SubmenuItemVariantAccent,
+			Confirm: m.makeConfirm(m.config.Confirm.Settings && len(risks) > 0,
+				applyLabel[1:len(applyLabel)-1]+"?",
+				strings.Join(risks, "\n\n"),
+				"Apply",
+			),
+			OnActivate: func() tea.Msg {
+				msg := editPrefs(label, edit)
+				if edited, ok := msg.(prefsEditedMsg); ok {
+					return stagedAppliedMsg(edited)
+				}
+				return msg
+			},
+		},
+		&ui.LabeledSubmenuItem{
+			Label:   "[Discard]",
+			Variant: ui.SubmenuItemVariantDanger,
+			OnActivate: func() tea.Msg {
+				return stagedDiscardedMsg{}
+			},
+		},
+	)
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/neuralinkcorp/tsui/config"
 	"github.com/neuralinkcorp/tsui/libts"
 	"github.com/neuralinkcorp/tsui/ui"
 	"github.com/neuralinkcorp/tsui/version"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 )
@@ -19,21 +24,6 @@ import (
 // This has to be a var or -X can't override it.
 var Version = "local"
 
-const (
-	// Rate at which to poll Tailscale for status updates.
-	tickInterval = 3 * time.Second
-
-	// Rate at which to gather latency from peers.
-	pingTickInterval = 6 * time.Second
-	// Per-peer ping timeout.
-	pingTimeout = 1 * time.Second
-
-	// How long to keep messages in the bottom bar.
-	errorLifetime   = 6 * time.Second
-	successLifetime = 3 * time.Second
-	tipLifetime     = 3 * time.Second
-)
-
 // The type of the bottom bar status message:
 //
 //	statusTypeError, statusTypeSuccess
@@ -45,12 +35,23 @@ const (
 	statusTypeTip
 )
 
-var ctx = context.Background()
+// Context for calls to tailscaled. Canceled when quitting so calls still running stop.
+var ctx, cancelCtx = context.WithCancel(context.Background())
 
 // Central model containing application state.
 type model struct {
+	// User configuration.
+	config config.Config
+	// Key bindings, with the user's overrides applied.
+	keys keymap
+	// Path to the config file and whether it exists, for display.
+	configPath  string
+	configFound bool
+
 	// Current Tailscale state info.
 	state libts.State
+	// Per-peer transfer rates computed from the state's byte counters.
+	traffic bandwidthTracker
 	// Ping results per peer.
 	pings map[tailcfg.StableNodeID]*ipnstate.PingResult
 	// Whether the user has write permissions to the Tailscale config.
@@ -61,6 +62,35 @@ type model struct {
 	deviceInfo *ui.AppmenuItem
 	exitNodes  *ui.AppmenuItem
 	settings   *ui.AppmenuItem
+	presets    *ui.AppmenuItem
+	whois      *ui.AppmenuItem
+	dns        *ui.AppmenuItem
+	bandwidth  *ui.AppmenuItem
+	configInfo *ui.AppmenuItem
+	allPrefs   *ui.AppmenuItem
+	historyLog *ui.AppmenuItem
+	commandLog *ui.AppmenuItem
+	// Clickable areas of the menu from the last render.
+	menuHits *ui.HitMap
+
+	// Address field of the WhoIs tool. Kept across menu updates so typing isn't interrupted.
+	whoisInput *ui.InputSubmenuItem
+	// Result of the last WhoIs lookup or nil if there hasn't been one.
+	whoisResult *apitype.WhoIsResponse
+
+	// Text fields of text settings, by setting name. Kept across menu updates so typing isn't
+	// interrupted.
+	settingInputs map[string]*ui.InputSubmenuItem
+
+	// Name field for saving a preset. Kept across menu updates so typing isn't interrupted.
+	presetInput *ui.InputSubmenuItem
+
+	// Name field of the DNS query tool. Kept across menu updates so typing isn't interrupted.
+	dnsInput *ui.InputSubmenuItem
+	// Record type to query for.
+	dnsQueryType string
+	// Result of the last DNS query or nil if there hasn't been one.
+	dnsResult *dnsResultMsg
 
 	// Current width of the terminal.
 	terminalWidth int
@@ -75,6 +105,30 @@ type model struct {
 	// is updated and used to keep track of status expiration messages.
 	statusGen int
 
+	// Whether the config's default menu has been opened yet.
+	openedDefaultMenu bool
+
+	// Whether the throughput graph is shown above the status bar.
+	showGraph bool
+	// Whether the key help overlay is shown instead of the menus.
+	showHelp bool
+	// Confirmation dialog shown instead of the menus, or nil if there isn't one.
+	confirm *ui.Confirm
+
+	// Whether setting changes are staged and applied together instead of right away.
+	staging bool
+	// Changes waiting to be applied in staged edit mode, in the order they were made.
+	staged []*stagedEdit
+
+	// Changes shown in the menus before tailscaled has confirmed them, by setting name or
+	// "exit-node".
+	inFlight map[string]*inFlightEdit
+
+	// Preference changes made in this session, oldest first.
+	history []*prefsChange
+	// Equivalent tailscale commands for the changes made in this session, oldest first.
+	commands []commandEntry
+
 	// Result of the update checker.
 	latestVersion string
 
@@ -84,14 +138,55 @@ type model struct {
 }
 
 // Initialize the application state.
-func initialModel() (model, error) {
+func initialModel(cfg config.Config, keys keymap, configPath string, configFound bool) (model, error) {
 	m := model{
+		config:      cfg,
+		keys:        keys,
+		configPath:  configPath,
+		configFound: configFound,
+
 		// Main menu items.
 		deviceInfo: &ui.AppmenuItem{Label: "This Device"},
 		exitNodes: &ui.AppmenuItem{Label: "Exit Nodes",
 			Submenu: ui.Submenu{Exclusivity: ui.SubmenuExclusivityOne},
 		},
-		settings: &ui.AppmenuItem{Label: "Settings"},
+		settings:   &ui.AppmenuItem{Label: "Settings"},
+		presets:    &ui.AppmenuItem{Label: "Presets"},
+		whois:      &ui.AppmenuItem{Label: "WhoIs"},
+		dns:        &ui.AppmenuItem{Label: "DNS"},
+		bandwidth:  &ui.AppmenuItem{Label: "Bandwidth"},
+		configInfo: &ui.AppmenuItem{Label: "Config"},
+		allPrefs:   &ui.AppmenuItem{Label: "All Preferences"},
+		historyLog: &ui.AppmenuItem{Label: "History"},
+		commandLog: &ui.AppmenuItem{Label: "Commands"},
+		menuHits:   &ui.HitMap{},
+
+		settingInputs: make(map[string]*ui.InputSubmenuItem),
+		inFlight:      make(map[string]*inFlightEdit),
+
+		dnsQueryType: libts.DNSQueryTypes[0],
+	}
+
+	m.whoisInput = &ui.InputSubmenuItem{
+		Label:       "Address",
+		Placeholder: "100.x.y.z or IP:port",
+		OnSubmit: func(addr string) tea.Msg {
+			whois, err := libts.WhoIs(ctx, strings.TrimSpace(addr))
+			if err != nil {
+				return errorMsg(err)
+			}
+			return whoisMsg(whois)
+		},
+	}
+
+	m.presetInput = &ui.InputSubmenuItem{
+		Label:       "Save As",
+		Placeholder: "Preset name",
+	}
+
+	m.dnsInput = &ui.InputSubmenuItem{
+		Label:       "Name",
+		Placeholder: "example.com",
 	}
 
 	state, err := libts.GetState(ctx)
@@ -101,31 +196,134 @@ func initialModel() (model, error) {
 
 	m.canWrite = libts.CanWrite(ctx)
 	m.state = state
+	m.traffic.update(state.SortedPeers, time.Now())
 	m.updateMenus()
+	m.openDefaultMenu()
 
 	return m, nil
 }
 
+// Open the main menu item named by the config's default_menu, once it's available.
+func (m *model) openDefaultMenu() {
+	if m.openedDefaultMenu || m.state.BackendState != ipn.Running.String() {
+		return
+	}
+	m.openedDefaultMenu = true
+
+	items := map[string]*ui.AppmenuItem{
+		"this-device": m.deviceInfo,
+		"exit-nodes":  m.exitNodes,
+		"settings":    m.settings,
+		"presets":     m.presets,
+		"whois":       m.whois,
+		"dns":         m.dns,
+		"bandwidth":   m.bandwidth,
+		"config":      m.configInfo,
+		"preferences": m.allPrefs,
+		"history":     m.historyLog,
+		"commands":    m.commandLog,
+	}
+	if item := items[m.config.DefaultMenu]; item != nil {
+		m.menu.Open(item)
+	}
+}
+
 // Bubbletea init function.
 func (m model) Init() tea.Cmd {
-	return tea.Batch(
+	cmds := []tea.Cmd{
 		// Perform our initial state fetch to populate menus
 		updateState,
-		// Run an initial batch of pings.
-		makeDoPings(m.state.SortedExitNodes),
 		// Kick off our ticks.
-		tea.Tick(tickInterval, func(_ time.Time) tea.Msg {
+		tea.Tick(m.config.TickInterval, func(_ time.Time) tea.Msg {
 			return tickMsg{}
 		}),
-		tea.Tick(pingTickInterval, func(_ time.Time) tea.Msg {
-			return pingTickMsg{}
-		}),
 		tea.Tick(ui.PoggersAnimationInterval, func(_ time.Time) tea.Msg {
 			return animationTickMsg{}
 		}),
 		// And fetch the latest version.
 		fetchLatestVersion,
-	)
+	}
+
+	if m.config.Ping.Enabled {
+		cmds = append(cmds,
+			// Run an initial batch of pings.
+			makeDoPings(m.state.SortedExitNodes, m.config.Ping.Timeout),
+			tea.Tick(m.config.Ping.Interval, func(_ time.Time) tea.Msg {
+				return pingTickMsg{}
+			}),
+		)
+	}
+
+	return tea.Batch(cmds...)
+}
+
+// Pick the theme named by the config, with the config's color overrides applied.
+func resolveTheme(cfg config.Config) (ui.Theme, error) {
+	// NO_COLOR wins over the config, including its color overrides.
+	if os.Getenv("NO_COLOR") != "" {
+		return ui.Themes["none"], nil
+	}
+
+	name := cfg.Theme
+	if name == "auto" {
+		if lipgloss.HasDarkBackground() {
+			name = "dark"
+		} else {
+			name = "light"
+		}
+	}
+
+	var theme ui.Theme
+	if custom, ok := cfg.Themes[name]; ok {
+		base := custom.Base
+		if base == "" {
+			base = "dark"
+		}
+		theme, ok = ui.Themes[base]
+		if !ok {
+			return theme, fmt.Errorf("themes.%s.base: %q is not a built-in theme", name, base)
+		}
+		theme = overrideColors(theme, custom.Colors)
+	} else if builtin, ok := ui.Themes[name]; ok {
+		theme = builtin
+	} else {
+		return theme, fmt.Errorf("theme: %q is not a built-in theme or defined in [themes]", name)
+	}
+
+	return overrideColors(theme, cfg.Colors), nil
+}
+
+// Replace a theme's colors with the ones set in colors.
+func overrideColors(theme ui.Theme, colors config.Colors) ui.Theme {
+	override := func(color *lipgloss.Color, value string) {
+		if value != "" {
+			*color = lipgloss.Color(value)
+		}
+	}
+	override(&theme.Primary, colors.Primary)
+	override(&theme.Secondary, colors.Secondary)
+	override(&theme.Red, colors.Red)
+	override(&theme.Blue, colors.Blue)
+	override(&theme.Green, colors.Green)
+	override(&theme.Yellow, colors.Yellow)
+	override(&theme.White, colors.White)
+	override(&theme.DarkGray, colors.DarkGray)
+	override(&theme.Black, colors.Black)
+	override(&theme.Muted, colors.Muted)
+	return theme
+}
+
+// Apply the config's theme and layout to the ui package.
+func applyUIConfig(cfg config.Config) error {
+	theme, err := resolveTheme(cfg)
+	if err != nil {
+		return err
+	}
+	ui.ApplyTheme(theme)
+
+	ui.AppmenuWidth = cfg.Layout.AppmenuWidth
+	ui.SubmenuWidth = cfg.Layout.SubmenuWidth
+	return nil
 }
 
 func mainError(err error) {
@@ -137,14 +335,56 @@ func mainError(err error) {
 }
 
 func main() {
-	m, err := initialModel()
+	configPath, err := config.Path()
+	if err != nil {
+		mainError(err)
+	}
+	cfg, configFound, err := config.Load(configPath)
+	if err != nil {
+		mainError(err)
+	}
+	libts.Timeout = cfg.RequestTimeout
+
+	// Dry-run mode applies to both the interface and the subcommands.
+	args, dryRun := extractDryRunFlag(os.Args[1:])
+	libts.DryRun = dryRun
+
+	// Scriptable subcommands.
+	if len(args) > 0 {
+		err := runCommand(args[0], args[1:], cfg)
+		var dryRun *libts.DryRunError
+		if errors.As(err, &dryRun) {
+			printDryRun(os.Stdout, dryRun)
+			return
+		}
+		if _, ok := err.(usageError); ok {
+			fmt.Fprint(os.Stderr, cliUsage+"\n")
+		}
+		if err != nil {
+			mainError(err)
+		}
+		return
+	}
+
+	// The theme, layout and keys only matter to the interface, so mistakes in them
+	// don't stop the subcommands from working.
+	err = applyUIConfig(cfg)
+	if err != nil {
+		mainError(fmt.Errorf("config file %s: %w", configPath, err))
+	}
+	keys, err := newKeymap(cfg.Keys)
+	if err != nil {
+		mainError(fmt.Errorf("config file %s: %w", configPath, err))
+	}
+
+	m, err := initialModel(cfg, keys, configPath, configFound)
 	if err != nil {
 		mainError(err)
 	}
 
 	// Enable "alternate screen" mode, a terminal convention designed for rendering
-	// full-screen, interactive UIs.
-	p := tea.NewProgram(m, tea.WithAltScreen())
+	// full-screen, interactive UIs, and mouse clicks and scrolling.
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
 	finalModel, err := p.Run()
 	if err != nil {
 		mainError(err)
@@ -49,8 +49,7 @@ func PoggersAnimationFrame(t int) string {
 
 			switch char {
 			case '.':
-				style = style.
-					Faint(true)
+				style = Mute(style)
 
 			case 'T', 'S', 'U', 'I':
 				if char == targetLetter {
@@ -72,3 +71,12 @@ func PoggersAnimationFrame(t int) string {
 
 	return frame.String()
 }
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Get the frame of the spinner shown on items waiting for a request, based on the current
+// time. The screen is redrawn on every animation tick, so the spinner keeps moving.
+func SpinnerFrame() string {
+	t := time.Now().UnixMilli() / PoggersAnimationInterval.Milliseconds()
+	return spinnerFrames[t%int64(len(spinnerFrames))]
+}
@@ -1,12 +1,19 @@
 package ui
 
 import (
+	"fmt"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
+// Width of main menu items in columns, not including the arrow, when there's enough room.
+var AppmenuWidth = 35
+
+// Width of the arrow after each main menu item.
+const appmenuArrowWidth = 3
+
 // An item in the main menu, containing a submenu.
 type AppmenuItem struct {
 	// The text to be displayed for this menu item.
@@ -17,31 +24,26 @@ type AppmenuItem struct {
 	Submenu Submenu
 }
 
-func (i *AppmenuItem) render(isSelected bool, isAnySubmenuOpen bool) string {
+func (i *AppmenuItem) render(isSelected bool, isAnySubmenuOpen bool, width int) string {
 	style := lipgloss.NewStyle()
 
 	if isSelected {
 		if isAnySubmenuOpen {
-			style = style.
-				Background(DarkGray)
+			style = Highlight(style, DarkGray, "")
 		} else {
-			style = style.
-				Background(Primary).
-				Foreground(Black)
+			style = Highlight(style, Primary, Black)
 		}
 	} else {
 		if isAnySubmenuOpen {
-			style = style.
-				Faint(true)
+			style = Mute(style)
 		} // else unstyled
 	}
 
 	content := RenderSplit(
 		" "+i.Label,
-		style.
-			Faint(true).
+		Mute(style).
 			Render(i.AdditionalLabel),
-		35,
+		width,
 		style,
 	)
 	arrow := style.
@@ -61,25 +63,133 @@ type Appmenu struct {
 	isOpen bool
 }
 
-// Render the menu to a string.
-func (appmenu *Appmenu) Render() string {
+// Render the menu to a string that fits in width columns and height lines. If the main
+// menu and submenu don't fit side by side, only the focused one is shown. Records the
+// clickable lines in hits.
+func (appmenu *Appmenu) Render(width int, height int, hits *HitMap) string {
+	hits.reset()
 	if len(appmenu.items) == 0 {
 		return ""
 	}
 
-	var s strings.Builder
+	selected := appmenu.items[appmenu.cursor]
 
-	for i, item := range appmenu.items {
-		if i > 0 {
-			s.WriteByte('\n')
+	if width < AppmenuWidth+appmenuArrowWidth+SubmenuWidth {
+		if appmenu.isOpen {
+			// Show which main menu item we're in, since the main menu is hidden.
+			title := Mute(lipgloss.NewStyle()).
+				Render(Truncate("< "+selected.Label, width))
+			submenuWidth := min(SubmenuWidth, width)
+			submenu := selected.Submenu.Render(true, submenuWidth, height-2)
+			appmenu.addSubmenuHits(hits, 0, 2, submenuWidth)
+			return title + "\n\n" + submenu
 		}
-		s.WriteString(item.render(i == appmenu.cursor, appmenu.isOpen))
+		return appmenu.renderItems(min(AppmenuWidth, width-appmenuArrowWidth), height, hits)
 	}
 
 	// Render the submenu to the right of the appmenu.
-	return lipgloss.JoinHorizontal(lipgloss.Top,
-		s.String(),
-		appmenu.items[appmenu.cursor].Submenu.Render(appmenu.isOpen))
+	items := appmenu.renderItems(AppmenuWidth, height, hits)
+	submenu := selected.Submenu.Render(appmenu.isOpen, SubmenuWidth, height)
+	appmenu.addSubmenuHits(hits, lipgloss.Width(items), 0, SubmenuWidth)
+	return lipgloss.JoinHorizontal(lipgloss.Top, items, submenu)
+}
+
+// Render the main menu items, each width columns wide plus the arrow. If there are more
+// than height items, they're split into pages and only the page with the cursor is shown,
+// with indicators for the pages above and below. Bubbletea renders a copy of the model, so
+// the page is worked out from the cursor alone instead of being remembered like a
+// submenu's viewport.
+func (appmenu *Appmenu) renderItems(width int, height int, hits *HitMap) string {
+	start, end := 0, len(appmenu.items)
+	if len(appmenu.items) > height {
+		// Leave room for both indicators.
+		pageSize := max(height, minSubmenuHeight) - 2
+		start = appmenu.cursor / pageSize * pageSize
+		end = min(start+pageSize, len(appmenu.items))
+	}
+
+	indicatorStyle := Mute(lipgloss.NewStyle()).
+		PaddingLeft(1).
+		Width(width + appmenuArrowWidth)
+
+	var lines []string
+	addIndicator := func(text string, target int) {
+		hits.add(hitRegion{x: 0, y: len(lines), width: width + appmenuArrowWidth, appmenuIndex: target, submenuIndex: lineAppmenuScroll})
+		lines = append(lines, indicatorStyle.Render(text))
+	}
+
+	if start > 0 {
+		addIndicator(fmt.Sprintf("↑ %d more", start), start-1)
+	}
+	for i := start; i < end; i++ {
+		hits.add(hitRegion{x: 0, y: len(lines), width: width + appmenuArrowWidth, appmenuIndex: i, submenuIndex: lineNone})
+		lines = append(lines, appmenu.items[i].render(i == appmenu.cursor, appmenu.isOpen, width))
+	}
+	if end < len(appmenu.items) {
+		addIndicator(fmt.Sprintf("↓ %d more", len(appmenu.items)-end), end)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Record the lines of the selected item's submenu, as last rendered at x, y.
+func (appmenu *Appmenu) addSubmenuHits(hits *HitMap, x int, y int, width int) {
+	for line, index := range appmenu.items[appmenu.cursor].Submenu.renderedLines {
+		if index == lineNone {
+			continue
+		}
+		hits.add(hitRegion{x: x, y: y + line, width: width, appmenuIndex: appmenu.cursor, submenuIndex: index})
+	}
+}
+
+// Handle a mouse event using the regions recorded by the last render. Clicking a main
+// menu item opens its submenu, clicking a submenu item activates it, and the scroll wheel
+// moves the cursor. Returns a bubbletea command that can be run asynchronously.
+func (appmenu *Appmenu) HandleMouse(msg tea.MouseMsg, hits *HitMap) tea.Cmd {
+	if msg.Action != tea.MouseActionPress {
+		return nil
+	}
+
+	switch msg.Button {
+	case tea.MouseButtonWheelUp:
+		appmenu.CursorUp()
+		return nil
+	case tea.MouseButtonWheelDown:
+		appmenu.CursorDown()
+		return nil
+	}
+	if msg.Button != tea.MouseButtonLeft {
+		return nil
+	}
+
+	region, ok := hits.at(msg.X, msg.Y)
+	if !ok || region.appmenuIndex >= len(appmenu.items) {
+		return nil
+	}
+	item := appmenu.items[region.appmenuIndex]
+
+	switch region.submenuIndex {
+	case lineAppmenuScroll:
+		appmenu.cursor = region.appmenuIndex
+		appmenu.isOpen = false
+		return nil
+	case lineNone:
+		if !appmenu.isOpen || appmenu.cursor != region.appmenuIndex {
+			appmenu.Open(item)
+		}
+		return nil
+	case lineScrollUp:
+		item.Submenu.PageUp()
+		return nil
+	case lineScrollDown:
+		item.Submenu.PageDown()
+		return nil
+	}
+
+	// Clicking an item in a submenu that's shown but not focused also focuses it.
+	appmenu.cursor = region.appmenuIndex
+	appmenu.isOpen = true
+	item.Submenu.setCursor(region.submenuIndex)
+	return item.Submenu.Activate()
 }
 
 // Move the cursor to the next selectable item in the currently active menu.
@@ -108,6 +218,42 @@ func (appmenu *Appmenu) CursorUp() {
 	}
 }
 
+// Move the cursor down by a page in the open submenu, or to the last main menu item.
+func (appmenu *Appmenu) PageDown() {
+	if appmenu.isOpen {
+		appmenu.items[appmenu.cursor].Submenu.PageDown()
+	} else {
+		appmenu.CursorEnd()
+	}
+}
+
+// Move the cursor up by a page in the open submenu, or to the first main menu item.
+func (appmenu *Appmenu) PageUp() {
+	if appmenu.isOpen {
+		appmenu.items[appmenu.cursor].Submenu.PageUp()
+	} else {
+		appmenu.CursorHome()
+	}
+}
+
+// Move the cursor to the first item in the currently active menu.
+func (appmenu *Appmenu) CursorHome() {
+	if appmenu.isOpen {
+		appmenu.items[appmenu.cursor].Submenu.ResetCursor()
+	} else {
+		appmenu.cursor = 0
+	}
+}
+
+// Move the cursor to the last item in the currently active menu.
+func (appmenu *Appmenu) CursorEnd() {
+	if appmenu.isOpen {
+		appmenu.items[appmenu.cursor].Submenu.CursorEnd()
+	} else {
+		appmenu.cursor = max(len(appmenu.items)-1, 0)
+	}
+}
+
 // Set the items list and ensure the cursor is within bounds.
 func (appmenu *Appmenu) SetItems(items []*AppmenuItem) {
 	appmenu.items = items
@@ -150,3 +296,29 @@ func (appmenu *Appmenu) IsSubmenuOpen() bool {
 func (appmenu *Appmenu) CloseSubmenu() {
 	appmenu.isOpen = false
 }
+
+// Select the given item and open its submenu. Does nothing if the item isn't in the menu.
+func (appmenu *Appmenu) Open(item *AppmenuItem) {
+	for i, candidate := range appmenu.items {
+		if candidate == item {
+			appmenu.cursor = i
+			appmenu.isOpen = true
+			item.Submenu.ResetCursor()
+			return
+		}
+	}
+}
+
+// Returns true if the open submenu has a text field capturing keyboard input.
+func (appmenu *Appmenu) IsEditing() bool {
+	return appmenu.isOpen && appmenu.items[appmenu.cursor].Submenu.IsEditing()
+}
+
+// Pass a keypress to the text field being edited in the open submenu.
+// Returns a bubbletea command that can be run asynchronously.
+func (appmenu *Appmenu) HandleKey(msg tea.KeyMsg, keys InputKeys) tea.Cmd {
+	if !appmenu.isOpen {
+		return nil
+	}
+	return appmenu.items[appmenu.cursor].Submenu.HandleKey(msg, keys)
+}
@@ -2,7 +2,9 @@ package ui
 
 import "github.com/charmbracelet/lipgloss"
 
-const (
+// Interface colors, set from the theme at startup. An empty color means the terminal's
+// default, in which case highlights fall back to reverse video.
+var (
 	Primary   = lipgloss.Color("207")
 	Secondary = lipgloss.Color("135")
 
@@ -13,4 +15,124 @@ const (
 	White    = lipgloss.Color("231")
 	DarkGray = lipgloss.Color("237")
 	Black    = lipgloss.Color("016")
+
+	// Color of de-emphasized text. If empty, the terminal's faint attribute is used instead.
+	Muted = lipgloss.Color("")
 )
+
+// A complete color palette.
+type Theme struct {
+	// Accent colors for the main menu and submenus.
+	Primary   lipgloss.Color
+	Secondary lipgloss.Color
+
+	// Status colors. Red is also used for dangerous actions.
+	Red    lipgloss.Color
+	Blue   lipgloss.Color
+	Green  lipgloss.Color
+	Yellow lipgloss.Color
+
+	// Text drawn on top of colored backgrounds.
+	White lipgloss.Color
+	Black lipgloss.Color
+	// Background of the main menu item whose submenu is open.
+	DarkGray lipgloss.Color
+
+	// De-emphasized text, or empty to use the faint attribute.
+	Muted lipgloss.Color
+}
+
+// Built-in themes, by name.
+var Themes = map[string]Theme{
+	// The original palette, designed for dark terminals.
+	"dark": {
+		Primary:   "207",
+		Secondary: "135",
+		Red:       "203",
+		Blue:      "039",
+		Green:     "040",
+		Yellow:    "214",
+		White:     "231",
+		Black:     "016",
+		DarkGray:  "237",
+	},
+	// Darker accents and a real gray for muted text, since faint text nearly disappears
+	// on light backgrounds.
+	"light": {
+		Primary:   "162",
+		Secondary: "097",
+		Red:       "160",
+		Blue:      "025",
+		Green:     "028",
+		Yellow:    "130",
+		White:     "231",
+		Black:     "016",
+		DarkGray:  "252",
+		Muted:     "243",
+	},
+	// Saturated colors and no faint text, for dark terminals.
+	"high-contrast": {
+		Primary:   "226",
+		Secondary: "051",
+		Red:       "196",
+		Blue:      "045",
+		Green:     "046",
+		Yellow:    "226",
+		White:     "231",
+		Black:     "016",
+		DarkGray:  "244",
+		Muted:     "252",
+	},
+	// The Okabe-Ito palette, which stays distinguishable with the common forms of color
+	// blindness. "Green" is a sky blue so it can't be confused with "Red".
+	"colorblind": {
+		Primary:   "#E69F00",
+		Secondary: "#CC79A7",
+		Red:       "#D55E00",
+		Blue:      "#0072B2",
+		Green:     "#56B4E9",
+		Yellow:    "#F0E442",
+		White:     "231",
+		Black:     "016",
+		DarkGray:  "237",
+	},
+	// No colors at all, only text attributes. Used for NO_COLOR.
+	"none": {},
+}
+
+// Make a theme's colors the current interface colors.
+func ApplyTheme(theme Theme) {
+	Primary = theme.Primary
+	Secondary = theme.Secondary
+	Red = theme.Red
+	Blue = theme.Blue
+	Green = theme.Green
+	Yellow = theme.Yellow
+	White = theme.White
+	Black = theme.Black
+	DarkGray = theme.DarkGray
+	Muted = theme.Muted
+}
+
+// De-emphasize a style, using the muted color if the theme has one and the style doesn't
+// already set a foreground color, or the faint attribute otherwise.
+func Mute(style lipgloss.Style) lipgloss.Style {
+	_, noForeground := style.GetForeground().(lipgloss.NoColor)
+	if Muted != "" && noForeground {
+		return style.Foreground(Muted)
+	}
+	return style.Faint(true)
+}
+
+// Highlight a style with a background color and a contrasting foreground color, or with
+// reverse video if the theme has no background color.
+func Highlight(style lipgloss.Style, background lipgloss.Color, foreground lipgloss.Color) lipgloss.Style {
+	if background == "" {
+		return style.Reverse(true)
+	}
+	style = style.Background(background)
+	if foreground != "" {
+		style = style.Foreground(foreground)
+	}
+	return style
+}
@@ -0,0 +1,104 @@
+package ui
+
+import (
+	"slices"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Keys a confirmation dialog responds to. Each action can have several keys.
+type ConfirmKeys struct {
+	// Confirm right away.
+	Yes []string
+	// Cancel right away.
+	Cancel []string
+	// Move the focus to the other button.
+	Switch []string
+	// Press the focused button.
+	Press []string
+}
+
+// A modal dialog asking the user to confirm an action before it runs. Cancel is focused
+// by default so an accidental enter does nothing.
+type Confirm struct {
+	// Short question, like "Log out?".
+	Title string
+	// Explanation of what the action does.
+	Body string
+	// Extra warning shown prominently, or empty for none.
+	Warning string
+	// Label of the confirm button, like "Log Out".
+	ConfirmLabel string
+	// Label of the cancel button, or empty for "Cancel".
+	CancelLabel string
+	// Command to run if the user confirms.
+	OnConfirm tea.Cmd
+	// Whether the confirm button is focused instead of cancel.
+	confirmFocused bool
+}
+
+// Message asking the application to show a confirmation dialog.
+type ConfirmMsg *Confirm
+
+// Handle a keypress using the given keys. Returns whether the dialog is finished, and the
+// confirmed command if the user confirmed.
+func (c *Confirm) HandleKey(msg tea.KeyMsg, keys ConfirmKeys) (done bool, cmd tea.Cmd) {
+	key := msg.String()
+	switch {
+	case slices.Contains(keys.Yes, key):
+		return true, c.OnConfirm
+	case slices.Contains(keys.Cancel, key):
+		return true, nil
+	case slices.Contains(keys.Switch, key):
+		c.confirmFocused = !c.confirmFocused
+	case slices.Contains(keys.Press, key):
+		if c.confirmFocused {
+			return true, c.OnConfirm
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Render the dialog's contents, wrapped to at most width columns.
+func (c *Confirm) Render(width int) string {
+	textStyle := lipgloss.NewStyle().
+		Width(min(60, width))
+
+	lines := []string{
+		lipgloss.NewStyle().
+			Bold(true).
+			Render(c.Title),
+		"",
+		textStyle.Render(c.Body),
+	}
+	if c.Warning != "" {
+		lines = append(lines,
+			"",
+			textStyle.
+				Foreground(Yellow).
+				Bold(true).
+				Render(c.Warning),
+		)
+	}
+
+	buttonStyle := lipgloss.NewStyle().
+		Padding(0, 1)
+	cancelStyle, confirmStyle := buttonStyle, buttonStyle
+	if c.confirmFocused {
+		confirmStyle = Highlight(confirmStyle, Red, Black)
+	} else {
+		cancelStyle = Highlight(cancelStyle, Secondary, Black)
+	}
+	cancelLabel := c.CancelLabel
+	if cancelLabel == "" {
+		cancelLabel = "Cancel"
+	}
+	lines = append(lines,
+		"",
+		cancelStyle.Render(cancelLabel)+"  "+confirmStyle.Render(c.ConfirmLabel),
+	)
+
+	return lipgloss.JoinVertical(lipgloss.Center, lines...)
+}
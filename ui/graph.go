@@ -0,0 +1,33 @@
+package ui
+
+import "strings"
+
+// Block characters used to draw sparklines, from lowest to highest.
+var sparklineLevels = []rune(" ▁▂▃▄▅▆▇█")
+
+// Render a one-line bar chart of values scaled against maxValue, exactly width cells wide.
+// Only the most recent values that fit are drawn, right-aligned so the newest value is
+// always at the right edge.
+func RenderSparkline(values []float64, maxValue float64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+
+	var s strings.Builder
+	s.WriteString(strings.Repeat(" ", width-len(values)))
+
+	for _, value := range values {
+		level := 0
+		if maxValue > 0 && value > 0 {
+			level = int(value / maxValue * float64(len(sparklineLevels)-1))
+			// Always show something for non-zero values, no matter how small.
+			level = min(max(level, 1), len(sparklineLevels)-1)
+		}
+		s.WriteRune(sparklineLevels[level])
+	}
+
+	return s.String()
+}
@@ -0,0 +1,127 @@
+package ui
+
+import (
+	"slices"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Keys a text field responds to while it's being edited. Each action can have several
+// keys. Any other printable key is typed into the field.
+type InputKeys struct {
+	// Submit the field.
+	Submit []string
+	// Stop editing without submitting.
+	Cancel []string
+	// Delete the last character.
+	Delete []string
+	// Empty the field.
+	Clear []string
+}
+
+// A submenu item containing a single-line text field. Activating the item starts
+// editing; while editing, the item captures all keyboard input until the user
+// submits the field or stops editing.
+type InputSubmenuItem struct {
+	// Name of the field, shown before the value.
+	Label string
+	// Text shown in a muted color when the field is empty.
+	Placeholder string
+	// Current contents of the field.
+	Value string
+	// Callback when the user submits the field with enter.
+	OnSubmit func(value string) tea.Msg
+	// Whether the field is emptied when submitted, so the placeholder shows again.
+	ClearOnSubmit bool
+	// Whether the field is currently capturing keyboard input.
+	isEditing bool
+}
+
+func (item *InputSubmenuItem) isSelectable() bool {
+	return true
+}
+
+func (item *InputSubmenuItem) onActivate() tea.Cmd {
+	item.isEditing = true
+	return nil
+}
+
+func (item *InputSubmenuItem) clearActiveFlag() {}
+
+// Handle a keypress while editing, using the given keys. Returns a bubbletea command if
+// the field was submitted.
+func (item *InputSubmenuItem) handleKey(msg tea.KeyMsg, keys InputKeys) tea.Cmd {
+	key := msg.String()
+	switch {
+	case slices.Contains(keys.Submit, key):
+		item.isEditing = false
+		if item.OnSubmit == nil {
+			return nil
+		}
+		// OnSubmit may be replaced before the command runs, so use the current one.
+		onSubmit := item.OnSubmit
+		value := item.Value
+		if item.ClearOnSubmit {
+			item.Value = ""
+		}
+		return func() tea.Msg {
+			return onSubmit(value)
+		}
+
+	case slices.Contains(keys.Cancel, key):
+		item.isEditing = false
+
+	case slices.Contains(keys.Delete, key):
+		if len(item.Value) > 0 {
+			runes := []rune(item.Value)
+			item.Value = string(runes[:len(runes)-1])
+		}
+
+	case slices.Contains(keys.Clear, key):
+		item.Value = ""
+
+	default:
+		if msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace {
+			item.Value += string(msg.Runes)
+		}
+	}
+
+	return nil
+}
+
+func (item *InputSubmenuItem) render(isSelected bool, isSubmenuOpen bool, width int) string {
+	style := lipgloss.NewStyle().
+		PaddingRight(1).
+		PaddingLeft(2).
+		Width(width)
+	valueStyle := lipgloss.NewStyle()
+
+	if isSubmenuOpen {
+		if isSelected && !item.isEditing {
+			style = Highlight(style, Secondary, Black)
+		} else if item.isEditing {
+			valueStyle = valueStyle.
+				Underline(true)
+		}
+	} else {
+		style = Mute(style)
+	}
+
+	value := item.Value
+	if item.isEditing {
+		value += "█"
+	} else if value == "" {
+		value = item.Placeholder
+		valueStyle = Mute(valueStyle)
+	}
+
+	// Keep the end of the value, where the cursor is, visible if it's too long.
+	label := Truncate(item.Label+": ", width-style.GetHorizontalPadding()-1)
+	valueWidth := width - style.GetHorizontalPadding() - lipgloss.Width(label)
+	if runes := []rune(value); len(runes) > valueWidth {
+		value = "…" + string(runes[len(runes)-max(valueWidth-1, 0):])
+	}
+
+	return style.Render(label + valueStyle.Render(value))
+}
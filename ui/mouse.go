@@ -0,0 +1,54 @@
+package ui
+
+// Special values of a submenu's rendered line items.
+const (
+	// A line that isn't an item, like a scroll indicator.
+	lineNone = -1
+	// The "↑ more" indicator.
+	lineScrollUp = -2
+	// The "↓ more" indicator.
+	lineScrollDown = -3
+	// A "more" indicator of the main menu. The region's appmenuIndex is the item to move
+	// the cursor to.
+	lineAppmenuScroll = -4
+)
+
+// A single line of the rendered menu that responds to the mouse.
+type hitRegion struct {
+	x, y, width int
+	// Index of the main menu item the line belongs to.
+	appmenuIndex int
+	// Index of the submenu item, or lineNone if this line is the main menu item itself, or
+	// lineScrollUp/lineScrollDown/lineAppmenuScroll for the scroll indicators.
+	submenuIndex int
+}
+
+// Areas of the rendered menu that respond to the mouse, recorded by Appmenu.Render.
+// Meant to be shared by pointer, since bubbletea renders a copy of the model.
+type HitMap struct {
+	// Screen position of the top left corner of the rendered menu. Set by the caller.
+	X, Y    int
+	regions []hitRegion
+}
+
+// Clear all regions before a new render.
+func (hits *HitMap) reset() {
+	hits.regions = hits.regions[:0]
+}
+
+// Record a line of the rendered menu at menu-relative coordinates.
+func (hits *HitMap) add(region hitRegion) {
+	hits.regions = append(hits.regions, region)
+}
+
+// Find the region at a screen position.
+func (hits *HitMap) at(x int, y int) (hitRegion, bool) {
+	x -= hits.X
+	y -= hits.Y
+	for _, region := range hits.regions {
+		if y == region.y && x >= region.x && x < region.x+region.width {
+			return region, true
+		}
+	}
+	return hitRegion{}, false
+}
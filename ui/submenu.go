@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"fmt"
 	"slices"
 	"strings"
 
@@ -18,11 +19,13 @@ type SubmenuItem interface {
 	onActivate() tea.Cmd
 	// If applicable, "un-toggles" the item.
 	clearActiveFlag()
-	// Renders the item. isSelected will always be false if isSelectable() returns false.
-	render(isSelected bool, isSubmenuOpen bool) string
+	// Renders the item to the given number of columns. isSelected will always be false if
+	// isSelectable() returns false.
+	render(isSelected bool, isSubmenuOpen bool, width int) string
 }
 
-const submenuItemWidth = 45
+// Width of submenu items in columns, when there's enough room.
+var SubmenuWidth = 45
 
 // Visual variant of a submenu item:
 //
@@ -56,6 +59,8 @@ type LabeledSubmenuItem struct {
 	Variant SubmenuItemVariant
 	// Callback when the item is activated.
 	OnActivate tea.Cmd
+	// If set, activating the item asks for confirmation before running OnActivate.
+	Confirm *Confirm
 	// Whether this item is visibly de-emphasized.
 	IsDim bool
 }
@@ -65,30 +70,37 @@ func (item *LabeledSubmenuItem) isSelectable() bool {
 }
 
 func (item *LabeledSubmenuItem) onActivate() tea.Cmd {
+	if item.Confirm != nil {
+		return confirmCmd(item.Confirm, item.OnActivate)
+	}
 	return item.OnActivate
 }
 
+// Create a command that asks for confirmation before running cmd.
+func confirmCmd(prompt *Confirm, cmd tea.Cmd) tea.Cmd {
+	confirm := *prompt
+	confirm.OnConfirm = cmd
+	return func() tea.Msg {
+		return ConfirmMsg(&confirm)
+	}
+}
+
 func (item *LabeledSubmenuItem) clearActiveFlag() {
 	// No-op because this item is not toggleable.
 }
 
-func (item *LabeledSubmenuItem) render(isSelected bool, isSubmenuOpen bool) string {
+func (item *LabeledSubmenuItem) render(isSelected bool, isSubmenuOpen bool, width int) string {
 	colorStyle := lipgloss.NewStyle()
 
 	if isSubmenuOpen {
 		if isSelected {
 			if item.Variant == SubmenuItemVariantDanger {
-				colorStyle = colorStyle.
-					Background(Red).
-					Foreground(Black)
+				colorStyle = Highlight(colorStyle, Red, Black)
 			} else {
-				colorStyle = colorStyle.
-					Background(Secondary).
-					Foreground(Black)
+				colorStyle = Highlight(colorStyle, Secondary, Black)
 			}
 		} else if item.IsDim {
-			colorStyle = colorStyle.
-				Faint(true)
+			colorStyle = Mute(colorStyle)
 		}
 
 		if item.Variant != SubmenuItemVariantDefault {
@@ -101,22 +113,20 @@ func (item *LabeledSubmenuItem) render(isSelected bool, isSubmenuOpen bool) stri
 			}
 		}
 	} else {
-		colorStyle = colorStyle.
-			Faint(true)
+		colorStyle = Mute(colorStyle)
 	}
 
 	outerStyle := colorStyle.
 		PaddingRight(1).
 		PaddingLeft(2).
-		Width(submenuItemWidth)
+		Width(width)
 
 	return outerStyle.Render(
 		RenderSplit(
 			colorStyle.Render(item.Label),
-			colorStyle.
-				Faint(true).
+			Mute(colorStyle).
 				Render(item.AdditionalLabel),
-			submenuItemWidth-outerStyle.GetHorizontalPadding(),
+			width-outerStyle.GetHorizontalPadding(),
 			colorStyle,
 		),
 	)
@@ -127,13 +137,20 @@ type ToggleableSubmenuItem struct {
 	LabeledSubmenuItem
 	// Whether this item is currently active.
 	IsActive bool
+	// Whether a change this item started is still being applied. Shows a spinner, and the
+	// item can't be activated again until it's done.
+	IsInFlight bool
 }
 
 func (item *ToggleableSubmenuItem) onActivate() tea.Cmd {
-	if item.IsActive {
+	if item.IsActive || item.IsInFlight {
 		return nil
 	}
-	item.IsActive = true
+	// Without a confirmation, the change starts right away.
+	if item.Confirm == nil {
+		item.IsActive = true
+		item.IsInFlight = true
+	}
 	return item.LabeledSubmenuItem.onActivate()
 }
 
@@ -141,7 +158,7 @@ func (item *ToggleableSubmenuItem) clearActiveFlag() {
 	item.IsActive = false
 }
 
-func (item *ToggleableSubmenuItem) render(isSelected bool, isSubmenuOpen bool) string {
+func (item *ToggleableSubmenuItem) render(isSelected bool, isSubmenuOpen bool, width int) string {
 	colorStyle := lipgloss.NewStyle()
 
 	if isSubmenuOpen {
@@ -156,12 +173,9 @@ func (item *ToggleableSubmenuItem) render(isSelected bool, isSubmenuOpen bool) s
 		}
 
 		if isSelected {
-			colorStyle = colorStyle.
-				Background(Secondary).
-				Foreground(Black)
+			colorStyle = Highlight(colorStyle, Secondary, Black)
 		} else if item.IsDim {
-			colorStyle = colorStyle.
-				Faint(true)
+			colorStyle = Mute(colorStyle)
 		}
 
 		if item.Variant != SubmenuItemVariantDefault {
@@ -174,26 +188,28 @@ func (item *ToggleableSubmenuItem) render(isSelected bool, isSubmenuOpen bool) s
 			}
 		}
 	} else {
-		colorStyle = colorStyle.
-			Faint(true)
+		colorStyle = Mute(colorStyle)
 	}
 
 	labelPrefix := " "
 	if item.IsActive {
 		labelPrefix = "*"
 	}
+	additionalLabel := item.AdditionalLabel
+	if item.IsInFlight {
+		additionalLabel = SpinnerFrame()
+	}
 
 	outerStyle := colorStyle.
 		Padding(0, 1).
-		Width(submenuItemWidth)
+		Width(width)
 
 	return outerStyle.Render(
 		RenderSplit(
 			labelPrefix+item.Label,
-			colorStyle.
-				Faint(true).
-				Render(item.AdditionalLabel),
-			submenuItemWidth-outerStyle.GetHorizontalPadding(),
+			Mute(colorStyle).
+				Render(additionalLabel),
+			width-outerStyle.GetHorizontalPadding(),
 			colorStyle,
 		),
 	)
@@ -205,6 +221,14 @@ type SettingSubmenuItem struct {
 	Label string
 	// Callback when a new value is selected.
 	OnChange func(newLabel string) tea.Msg
+	// If set, called with the next value before changing to it. If it returns a dialog,
+	// the change only happens once the user confirms.
+	Confirm func(newLabel string) *Confirm
+	// Whether the displayed value is a pending change that hasn't been applied yet.
+	IsPending bool
+	// Whether the displayed value is still being applied. Shows a spinner, and the value
+	// can't be changed again until it's done.
+	IsInFlight bool
 	// The value options.
 	options []string
 	// The currently selected value.
@@ -246,32 +270,46 @@ func (item *SettingSubmenuItem) isSelectable() bool {
 }
 
 func (item *SettingSubmenuItem) onActivate() tea.Cmd {
-	item.selected++
-	if item.selected >= len(item.options) {
-		item.selected = 0
+	if item.IsInFlight {
+		return nil
 	}
-	newLabel := item.options[item.selected]
-	return func() tea.Msg {
+
+	next := item.selected + 1
+	if next >= len(item.options) {
+		next = 0
+	}
+	newLabel := item.options[next]
+	change := func() tea.Msg {
 		return item.OnChange(newLabel)
 	}
+
+	// Leave the displayed value alone until the change is confirmed; the next state update
+	// will show it.
+	if item.Confirm != nil {
+		if prompt := item.Confirm(newLabel); prompt != nil {
+			return confirmCmd(prompt, change)
+		}
+	}
+
+	item.selected = next
+	item.IsInFlight = true
+	return change
 }
 
 func (item *SettingSubmenuItem) clearActiveFlag() {}
 
-func (item *SettingSubmenuItem) render(isSelected bool, isSubmenuOpen bool) string {
+func (item *SettingSubmenuItem) render(isSelected bool, isSubmenuOpen bool, width int) string {
 	selectedLabel := item.options[item.selected]
 
 	style := lipgloss.NewStyle().
 		PaddingRight(1).
 		PaddingLeft(2).
-		Width(submenuItemWidth)
+		Width(width)
 	selectedLabelStyle := lipgloss.NewStyle()
 
 	if isSubmenuOpen {
 		if isSelected {
-			style = style.
-				Background(Secondary).
-				Foreground(Black)
+			style = Highlight(style, Secondary, Black)
 
 			selectedLabelStyle = selectedLabelStyle.
 				Bold(true)
@@ -292,15 +330,25 @@ func (item *SettingSubmenuItem) render(isSelected bool, isSubmenuOpen bool) stri
 				Foreground(color)
 		}
 	} else {
-		style = style.
-			Faint(true)
+		style = Mute(style)
+	}
+
+	value := selectedLabelStyle.Render(selectedLabel)
+	if item.IsInFlight {
+		value = SpinnerFrame() + " " + value
+	} else if item.IsPending {
+		markerStyle := lipgloss.NewStyle()
+		if isSubmenuOpen && !isSelected {
+			markerStyle = markerStyle.Foreground(Yellow)
+		}
+		value = markerStyle.Render("● ") + value
 	}
 
 	return style.Render(
 		RenderSplit(
 			item.Label,
-			selectedLabelStyle.Render(selectedLabel),
-			submenuItemWidth-style.GetHorizontalPadding(),
+			value,
+			width-style.GetHorizontalPadding(),
 			lipgloss.NewStyle(),
 		),
 	)
@@ -319,9 +367,8 @@ func (d *DividerSubmenuItem) onActivate() tea.Cmd {
 
 func (d *DividerSubmenuItem) clearActiveFlag() {}
 
-func (d *DividerSubmenuItem) render(isSelected bool, isSubmenuOpen bool) string {
-	return lipgloss.NewStyle().
-		Faint(true).
+func (d *DividerSubmenuItem) render(isSelected bool, isSubmenuOpen bool, width int) string {
+	return Mute(lipgloss.NewStyle()).
 		Render("  --")
 }
 
@@ -338,7 +385,7 @@ func (s *SpacerSubmenuItem) onActivate() tea.Cmd {
 
 func (s *SpacerSubmenuItem) clearActiveFlag() {}
 
-func (s *SpacerSubmenuItem) render(isSelected bool, isSubmenuOpen bool) string {
+func (s *SpacerSubmenuItem) render(isSelected bool, isSubmenuOpen bool, width int) string {
 	return ""
 }
 
@@ -358,11 +405,10 @@ func (i *TitleSubmenuItem) onActivate() tea.Cmd {
 
 func (i *TitleSubmenuItem) clearActiveFlag() {}
 
-func (i *TitleSubmenuItem) render(isSelected bool, isSubmenuOpen bool) string {
-	return lipgloss.NewStyle().
-		Faint(true).
+func (i *TitleSubmenuItem) render(isSelected bool, isSubmenuOpen bool, width int) string {
+	return Mute(lipgloss.NewStyle()).
 		PaddingLeft(2).
-		Render(i.Label)
+		Render(Truncate(i.Label, width-2))
 }
 
 type SubmenuExclusivity int
@@ -381,18 +427,89 @@ type Submenu struct {
 	Exclusivity SubmenuExclusivity
 	items       []SubmenuItem
 	cursor      int
+	// Index of the first item in the viewport. Updated by Render so the viewport only
+	// scrolls when the cursor would leave it.
+	offset int
+	// Number of items shown by the last render, used as the page size.
+	pageSize int
+	// Item index shown on each line by the last render, or one of the line constants.
+	renderedLines []int
+}
+
+// Minimum height of the viewport: an item plus both scroll indicators.
+const minSubmenuHeight = 3
+
+// Render the submenu to a string, with items width columns wide. If there are more than
+// height items, only a window of them around the cursor is shown, with indicators for
+// the hidden items above and below.
+func (submenu *Submenu) Render(isSubmenuOpen bool, width int, height int) string {
+	height = max(height, minSubmenuHeight)
+	start, end := submenu.scrollToCursor(height)
+	submenu.pageSize = end - start
+
+	indicatorStyle := Mute(lipgloss.NewStyle()).
+		PaddingLeft(2).
+		Width(width)
+
+	var lines []string
+	submenu.renderedLines = submenu.renderedLines[:0]
+	if start > 0 {
+		lines = append(lines, indicatorStyle.Render(fmt.Sprintf("↑ %d more", start)))
+		submenu.renderedLines = append(submenu.renderedLines, lineScrollUp)
+	}
+	for i := start; i < end; i++ {
+		item := submenu.items[i]
+		lines = append(lines, item.render(i == submenu.cursor && item.isSelectable(), isSubmenuOpen, width))
+		if item.isSelectable() {
+			submenu.renderedLines = append(submenu.renderedLines, i)
+		} else {
+			submenu.renderedLines = append(submenu.renderedLines, lineNone)
+		}
+	}
+	if end < len(submenu.items) {
+		lines = append(lines, indicatorStyle.Render(fmt.Sprintf("↓ %d more", len(submenu.items)-end)))
+		submenu.renderedLines = append(submenu.renderedLines, lineScrollDown)
+	}
+	return strings.Join(lines, "\n")
 }
 
-// Render the submenu to a string.
-func (submenu *Submenu) Render(isSubmenuOpen bool) string {
-	var s strings.Builder
-	for i, item := range submenu.items {
-		if i > 0 {
-			s.WriteByte('\n')
+// Get the end of the viewport starting at item start, leaving room for the indicators.
+func (submenu *Submenu) viewportEnd(start int, height int) int {
+	rows := height
+	if start > 0 {
+		rows--
+	}
+	if start+rows >= len(submenu.items) {
+		return len(submenu.items)
+	}
+	// Room for the "more" indicator at the bottom.
+	return start + rows - 1
+}
+
+// Move the viewport the least amount needed to show the cursor, and return its bounds.
+func (submenu *Submenu) scrollToCursor(height int) (start int, end int) {
+	if len(submenu.items) <= height {
+		submenu.offset = 0
+		return 0, len(submenu.items)
+	}
+
+	// Don't scroll past the point where the last item is at the bottom.
+	start = min(submenu.offset, len(submenu.items)-height+1)
+
+	if submenu.cursor < start {
+		start = submenu.cursor
+		// Show the titles and spacers above the cursor when scrolling up into a section.
+		for start > 0 && !submenu.items[start-1].isSelectable() &&
+			submenu.cursor < submenu.viewportEnd(start-1, height) {
+			start--
 		}
-		s.WriteString(item.render(i == submenu.cursor && item.isSelectable(), isSubmenuOpen))
 	}
-	return s.String()
+	for submenu.cursor >= submenu.viewportEnd(start, height) {
+		start++
+	}
+
+	submenu.offset = start
+	return start, submenu.viewportEnd(start, height)
 }
 
 // Move the cursor to the next selectable item.
@@ -415,6 +532,49 @@ func (submenu *Submenu) CursorUp() {
 	}
 }
 
+// Move the cursor down by a page of items, to a selectable item.
+func (submenu *Submenu) PageDown() {
+	target := min(submenu.cursor+max(submenu.pageSize-1, 1), len(submenu.items)-1)
+	for i := target; i > submenu.cursor; i-- {
+		if submenu.items[i].isSelectable() {
+			submenu.cursor = i
+			return
+		}
+	}
+	// Nothing selectable within the page, so go to the next selectable item past it.
+	submenu.CursorDown()
+}
+
+// Move the cursor up by a page of items, to a selectable item.
+func (submenu *Submenu) PageUp() {
+	target := max(submenu.cursor-max(submenu.pageSize-1, 1), 0)
+	for i := target; i < submenu.cursor; i++ {
+		if submenu.items[i].isSelectable() {
+			submenu.cursor = i
+			return
+		}
+	}
+	// Nothing selectable within the page, so go to the previous selectable item before it.
+	submenu.CursorUp()
+}
+
+// Move the cursor to the last selectable item.
+func (submenu *Submenu) CursorEnd() {
+	for i := len(submenu.items) - 1; i >= 0; i-- {
+		if submenu.items[i].isSelectable() {
+			submenu.cursor = i
+			return
+		}
+	}
+}
+
+// Move the cursor to an item, if it's selectable.
+func (submenu *Submenu) setCursor(i int) {
+	if i >= 0 && i < len(submenu.items) && submenu.items[i].isSelectable() {
+		submenu.cursor = i
+	}
+}
+
 // Reset the cursor to the first selectable item.
 func (submenu *Submenu) ResetCursor() {
 	for i, item := range submenu.items {
@@ -453,12 +613,37 @@ func (submenu *Submenu) Activate() tea.Cmd {
 		return nil
 	}
 
+	item := submenu.items[submenu.cursor]
+	// Leave the other items alone while the item's last change is still being applied.
+	if toggleable, ok := item.(*ToggleableSubmenuItem); ok && toggleable.IsInFlight {
+		return nil
+	}
+
 	if submenu.Exclusivity == SubmenuExclusivityOne {
 		for _, item := range submenu.items {
 			item.clearActiveFlag()
 		}
 	}
 
-	item := submenu.items[submenu.cursor]
 	return item.onActivate()
 }
+
+// Returns true if the currently selected item is a text field that is capturing keyboard input.
+func (submenu *Submenu) IsEditing() bool {
+	if submenu.cursor < 0 || submenu.cursor >= len(submenu.items) {
+		return false
+	}
+
+	input, ok := submenu.items[submenu.cursor].(*InputSubmenuItem)
+	return ok && input.isEditing
+}
+
+// Pass a keypress to the text field being edited. Does nothing if IsEditing() is false.
+// Returns a bubbletea command that can be run asynchronously.
+func (submenu *Submenu) HandleKey(msg tea.KeyMsg, keys InputKeys) tea.Cmd {
+	if !submenu.IsEditing() {
+		return nil
+	}
+
+	return submenu.items[submenu.cursor].(*InputSubmenuItem).handleKey(msg, keys)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 )
 
 // Format a Duration to a human-friendly string.
@@ -45,10 +46,29 @@ func FormatBytes(bytes int64) string {
 	}
 }
 
+// Format a transfer rate in bytes per second to a human-friendly string.
+func FormatRate(bytesPerSecond float64) string {
+	return FormatBytes(int64(math.Round(bytesPerSecond))) + "/s"
+}
+
+// Shorten a possibly styled string to at most width columns, ending it with an ellipsis
+// if anything was cut off.
+func Truncate(s string, width int) string {
+	return ansi.Truncate(s, max(width, 0), "…")
+}
+
 // Combine a left-aligned and a right-aligned string into one fixed-width line.
 // Takes a style which is used for formatting the left-side padding, in case
-// a uniform background is required.
+// a uniform background is required. If both don't fit, the right side is shortened
+// first, down to half the line, then the left side.
 func RenderSplit(left string, right string, width int, style lipgloss.Style) string {
+	if lipgloss.Width(left)+lipgloss.Width(right) >= width {
+		// Keep at least one column between the two sides.
+		rightWidth := max(width-lipgloss.Width(left)-1, min(lipgloss.Width(right), width/2))
+		right = Truncate(right, rightWidth)
+		left = Truncate(left, width-lipgloss.Width(right)-1)
+	}
+
 	left = style.
 		Width(width - lipgloss.Width(right)).
 		Render(left)
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -9,6 +11,7 @@ import (
 	"github.com/neuralinkcorp/tsui/libts"
 	"github.com/neuralinkcorp/tsui/ui"
 	"github.com/neuralinkcorp/tsui/version"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
@@ -29,6 +32,19 @@ type stateMsg libts.State
 // Message with ping results ready to be stored in the model.
 type pingResultsMsg map[tailcfg.StableNodeID]*ipnstate.PingResult
 
+// Message containing the result of a WhoIs lookup.
+type whoisMsg *apitype.WhoIsResponse
+
+// Message containing the result of a DNS query.
+type dnsResultMsg struct {
+	name      string
+	queryType string
+	result    *libts.DNSQueryResult
+}
+
+// Message to change the record type used by DNS queries.
+type dnsQueryTypeMsg string
+
 // Message containing the latest version of tsui fetched from GitHub.
 type latestVersionMsg string
 
@@ -57,12 +73,12 @@ func updateState() tea.Msg {
 }
 
 // Creates a command to gets the current latency of the specified peers. Takes some time.
-func makeDoPings(peers []*ipnstate.PeerStatus) tea.Cmd {
+func makeDoPings(peers []*ipnstate.PeerStatus, timeout time.Duration) tea.Cmd {
 	return func() tea.Msg {
 		pings := make(map[tailcfg.StableNodeID]*ipnstate.PingResult)
 
 		for _, peer := range peers {
-			ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+			ctx, cancel := context.WithTimeout(ctx, timeout)
 			result, err := libts.PingPeer(ctx, peer)
 			cancel()
 
@@ -76,13 +92,41 @@ func makeDoPings(peers []*ipnstate.PeerStatus) tea.Cmd {
 	}
 }
 
-// Command that updates the Tailscale preferences and triggers a state update.
-func editPrefs(maskedPrefs *ipn.MaskedPrefs) tea.Msg {
-	err := libts.EditPrefs(ctx, maskedPrefs)
+// Command that cancels the calls to tailscaled that are still running, then quits.
+func quit() tea.Msg {
+	cancelCtx()
+	return tea.Quit()
+}
+
+// Command that updates the Tailscale preferences and triggers a prefsEditedMsg so the
+// change can be undone. Takes a description of the change. The preferences are fetched
+// right before the edit, so undoing it restores what was really in effect.
+func editPrefs(label string, maskedPrefs *ipn.MaskedPrefs) tea.Msg {
+	prefs, err := libts.Prefs(ctx)
+	if err != nil {
+		return errorMsg(err)
+	}
+	err = libts.EditPrefs(ctx, maskedPrefs)
 	if err != nil {
 		return errorMsg(err)
 	}
-	return updateState()
+	return prefsEditedMsg{
+		time:   time.Now(),
+		label:  label,
+		edit:   maskedPrefs,
+		revert: libts.RevertEdit(prefs, maskedPrefs),
+	}
+}
+
+// Creates a command that resolves a name through tailscaled and triggers a dnsResultMsg.
+func makeQueryDNS(name string, queryType string) tea.Cmd {
+	return func() tea.Msg {
+		result, err := libts.QueryDNS(ctx, name, queryType)
+		if err != nil {
+			return errorMsg(err)
+		}
+		return dnsResultMsg{name: name, queryType: queryType, result: result}
+	}
 }
 
 // Command that fetches the latest version of tsui.
@@ -109,42 +153,109 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "q":
-			return m, tea.Quit
-		case "esc":
+		context := m.keyContext()
+
+		// While the help overlay is open, any key closes it.
+		if m.showHelp && msg.String() != emergencyQuitKey {
+			m.showHelp = false
+			return m, nil
+		}
+
+		// While a confirmation dialog is open, it gets every key except the emergency quit
+		// and help keys.
+		if context == keyContextConfirm && msg.String() != emergencyQuitKey {
+			if m.keys.lookup(msg.String(), context) == actionHelp {
+				m.showHelp = true
+				return m, nil
+			}
+			done, cmd := m.confirm.HandleKey(msg, m.keys.confirmKeys())
+			if done {
+				m.confirm = nil
+			}
+			return m, cmd
+		}
+
+		// While a text field is being edited, it gets every key except the emergency quit
+		// and help keys.
+		if context == keyContextInput && msg.String() != emergencyQuitKey {
+			if m.keys.lookup(msg.String(), context) == actionHelp {
+				m.showHelp = true
+				return m, nil
+			}
+			return m, m.menu.HandleKey(msg, m.keys.inputKeys())
+		}
+
+		switch m.keys.lookup(msg.String(), context) {
+		case actionQuit:
+			return m, quit
+		case actionClose:
 			if m.menu.IsSubmenuOpen() {
 				m.menu.CloseSubmenu()
 			} else {
-				return m, tea.Quit
+				return m, quit
 			}
 
-		case "left", "h", "a":
+		case actionBack:
 			m.menu.CloseSubmenu()
-		case "up", "k", "w":
+		case actionUp:
 			m.menu.CursorUp()
-		case "down", "j", "s":
+		case actionDown:
 			m.menu.CursorDown()
-		case "right", "l", "d":
+		case actionPageUp:
+			m.menu.PageUp()
+		case actionPageDown:
+			m.menu.PageDown()
+		case actionHome:
+			m.menu.CursorHome()
+		case actionEnd:
+			m.menu.CursorEnd()
+		case actionOpen:
 			if !m.menu.IsSubmenuOpen() {
 				return m, m.menu.Activate()
 			}
 
-		case "enter", " ":
+		case actionActivate:
 			return m, m.menu.Activate()
 
+		case actionHelp:
+			m.showHelp = true
+
+		case actionUndo:
+			return m, m.undo()
+
+		// Give up on requests tailscaled hasn't answered. Each one reports its own error.
+		case actionAbort:
+			libts.AbortCalls()
+
+		// Toggle the throughput graph.
+		case actionToggleGraph:
+			m.showGraph = !m.showGraph
+
+		// Jump straight to the WhoIs address field.
+		case actionWhois:
+			if m.state.BackendState == ipn.Running.String() {
+				m.menu.Open(m.whois)
+				return m, m.menu.Activate()
+			}
+
 		// Global action hotkey.
-		case ".":
+		case actionToggleConnection:
 			switch m.state.BackendState {
 			// If running, stop Tailscale.
 			case ipn.Running.String():
-				return m, func() tea.Msg {
+				down := func() tea.Msg {
 					err := libts.Down(ctx)
 					if err != nil {
 						return errorMsg(err)
 					}
-					return updateState()
+					return commandRanMsg{"Disconnect", "tailscale down", updateState()}
 				}
+				if confirm := m.disconnectConfirm(); confirm != nil {
+					confirm.OnConfirm = down
+					m.confirm = confirm
+					return m, nil
+				}
+				return m, down
 
 			// If stopped, start Tailscale.
 			case ipn.Stopped.String():
@@ -153,7 +264,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					if err != nil {
 						return errorMsg(err)
 					}
-					return updateState()
+					return commandRanMsg{"Connect", "tailscale up", updateState()}
 				}
 
 			// If we need to login...
@@ -166,7 +277,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if err != nil {
 							return errorMsg(err)
 						}
-						return successMsg("Starting login flow. This may take a few seconds.")
+						return commandRanMsg{"Log In", "tailscale login", successMsg("Starting login flow. This may take a few seconds.")}
 					}
 				} else if browser.IsSupported() {
 					// If the auth flow has already started, we need to open the browser ourselves.
@@ -195,19 +306,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+	case tea.MouseMsg:
+		// The menu only takes the mouse when it's shown and focused.
+		context := m.keyContext()
+		if m.showHelp || (context != keyContextMainMenu && context != keyContextSubmenu) {
+			return m, nil
+		}
+		return m, m.menu.HandleMouse(msg, m.menuHits)
+
 	// On ticks, run the appropriate commands, and kick off the next tick.
 	case tickMsg:
 		return m, tea.Batch(
 			updateState,
-			tea.Tick(tickInterval, func(_ time.Time) tea.Msg {
+			tea.Tick(m.config.TickInterval, func(_ time.Time) tea.Msg {
 				return tickMsg{}
 			}),
 		)
 	case pingTickMsg:
 		// For now we'll just run this on our exit nodes.
 		return m, tea.Batch(
-			makeDoPings(m.state.SortedExitNodes),
-			tea.Tick(pingTickInterval, func(_ time.Time) tea.Msg {
+			makeDoPings(m.state.SortedExitNodes, m.config.Ping.Timeout),
+			tea.Tick(m.config.Ping.Interval, func(_ time.Time) tea.Msg {
 				return pingTickMsg{}
 			}),
 		)
@@ -220,10 +339,107 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// When our updaters return, update our model and refresh the menus.
 	case stateMsg:
 		m.state = libts.State(msg)
+		m.traffic.update(m.state.SortedPeers, time.Now())
+		m.clearFinishedInFlight()
 		m.updateMenus()
+		m.openDefaultMenu()
 	case pingResultsMsg:
 		m.pings = msg
 		m.updateMenus()
+	// Show changes in the menus while they're being applied, and snap back if they fail.
+	case inFlightMsg:
+		return m, m.startInFlight(msg)
+	case inFlightDoneMsg:
+		m.finishInFlight(msg)
+		return m.Update(msg.result)
+	// Keep track of preference changes for undo.
+	case prefsEditedMsg:
+		change := prefsChange(msg)
+		m.recordChange(&change)
+		command := libts.CLICommand(change.edit, m.state.SortedPeers)
+		m.recordCommand(change.label, command)
+		m.updateMenus()
+		return m, tea.Batch(
+			updateState,
+			func() tea.Msg {
+				return successMsg(fmt.Sprintf("%s (%s). Press %s to undo.", change.label, command, m.keys.hint(actionUndo)))
+			},
+		)
+	case stagedAppliedMsg:
+		m.staged = nil
+		return m.Update(prefsEditedMsg(msg))
+	case stagedDiscardedMsg:
+		m.staged = nil
+		m.updateMenus()
+		return m, func() tea.Msg {
+			return tipMsg("Discarded the pending changes.")
+		}
+	case stageEditMsg:
+		m.stageEdit(msg)
+		m.updateMenus()
+	case stagingModeMsg:
+		if !msg && len(m.pendingEdits()) > 0 {
+			m.updateMenus()
+			return m, func() tea.Msg {
+				return tipMsg("Apply or discard the pending changes first.")
+			}
+		}
+		m.staging = bool(msg)
+		m.staged = nil
+		m.updateMenus()
+	case undoMsg:
+		return m, m.undo()
+	case undoFailedMsg:
+		msg.change.undoing = false
+		m.updateMenus()
+		return m.Update(errorMsg(msg.err))
+	case prefsUndoneMsg:
+		msg.undone = true
+		msg.undoing = false
+		command := libts.CLICommand(msg.revert, m.state.SortedPeers)
+		m.recordCommand("Undo "+msg.label, command)
+		m.updateMenus()
+		return m, tea.Batch(
+			updateState,
+			func() tea.Msg {
+				return successMsg(fmt.Sprintf("Undid %s (%s).", msg.label, command))
+			},
+		)
+	case commandRanMsg:
+		m.recordCommand(msg.label, msg.command)
+		m.updateMenus()
+		return m.Update(msg.next)
+	case commandsExportedMsg:
+		return m, func() tea.Msg {
+			return successMsg(fmt.Sprintf("Exported %d commands to %s.", len(m.commands), msg))
+		}
+
+	case ui.ConfirmMsg:
+		m.confirm = msg
+	case applyPresetMsg:
+		return m.Update(m.applyPreset(string(msg)))
+	case savePresetMsg:
+		return m, m.savePreset(string(msg))
+	case presetSaveFailedMsg:
+		delete(m.config.Presets, msg.name)
+		m.updateMenus()
+		return m.Update(errorMsg(msg.err))
+	case presetSavedMsg:
+		m.presetInput.Value = ""
+		m.configFound = true
+		m.updateMenus()
+		return m, func() tea.Msg {
+			return successMsg(fmt.Sprintf("Saved preset %s to %s.", msg.name, m.configPath))
+		}
+	case whoisMsg:
+		m.whoisResult = msg
+		m.updateMenus()
+	case dnsResultMsg:
+		m.dnsResult = &msg
+		m.updateMenus()
+	case dnsQueryTypeMsg:
+		m.dnsQueryType = string(msg)
+		m.updateMenus()
 
 	// When we get our latest version, just store it for (potential) display on exit.
 	case latestVersionMsg:
@@ -231,21 +447,28 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	// Display status bar notices.
 	case errorMsg, successMsg, tipMsg:
+		// In dry-run mode, changes come back as errors describing what would have been sent.
+		var dryRun *libts.DryRunError
+		if err, ok := msg.(errorMsg); ok && errors.As(err, &dryRun) {
+			m.confirm = dryRunDialog(dryRun)
+			return m, nil
+		}
+
 		var lifetime time.Duration
 
 		switch msg := msg.(type) {
 		case errorMsg:
 			m.statusType = statusTypeError
 			m.statusText = msg.Error()
-			lifetime = errorLifetime
+			lifetime = m.config.Messages.ErrorLifetime
 		case successMsg:
 			m.statusType = statusTypeSuccess
 			m.statusText = string(msg)
-			lifetime = successLifetime
+			lifetime = m.config.Messages.SuccessLifetime
 		case tipMsg:
 			m.statusType = statusTypeTip
 			m.statusText = string(msg)
-			lifetime = tipLifetime
+			lifetime = m.config.Messages.TipLifetime
 		}
 
 		m.statusGen++
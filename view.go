@@ -3,13 +3,22 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/neuralinkcorp/tsui/browser"
+	"github.com/neuralinkcorp/tsui/libts"
 	"github.com/neuralinkcorp/tsui/ui"
 	"tailscale.com/ipn"
 )
 
+const (
+	// Width of the labels to the left of the throughput graph.
+	graphLabelWidth = 22
+	// Terminals shorter than this get a single-line throughput graph.
+	compactGraphMinHeight = 30
+)
+
 // Format the status button in the header bar.
 func renderStatusButton(backendState string, isUsingExitNode bool) string {
 	buttonStyle := lipgloss.NewStyle().
@@ -17,21 +26,15 @@ func renderStatusButton(backendState string, isUsingExitNode bool) string {
 
 	switch backendState {
 	case ipn.NeedsLogin.String():
-		return buttonStyle.
-			Background(ui.Yellow).
-			Foreground(ui.Black).
+		return ui.Highlight(buttonStyle, ui.Yellow, ui.Black).
 			Render("Needs Login")
 
 	case ipn.NeedsMachineAuth.String():
-		return buttonStyle.
-			Background(ui.Yellow).
-			Foreground(ui.Black).
+		return ui.Highlight(buttonStyle, ui.Yellow, ui.Black).
 			Render("Needs Machine Auth")
 
 	case ipn.Starting.String():
-		return buttonStyle.
-			Background(ui.Blue).
-			Foreground(ui.White).
+		return ui.Highlight(buttonStyle, ui.Blue, ui.White).
 			Render("Starting...")
 
 	case ipn.Running.String():
@@ -40,21 +43,15 @@ func renderStatusButton(backendState string, isUsingExitNode bool) string {
 			text += " - Exit Node"
 		}
 
-		return buttonStyle.
-			Background(ui.Green).
-			Foreground(ui.Black).
+		return ui.Highlight(buttonStyle, ui.Green, ui.Black).
 			Render(text)
 
 	case ipn.Stopped.String():
-		return buttonStyle.
-			Background(ui.Red).
-			Foreground(ui.Black).
+		return ui.Highlight(buttonStyle, ui.Red, ui.Black).
 			Render("Not Connected")
 
 	case ipn.NoState.String():
-		return buttonStyle.
-			Background(ui.Blue).
-			Foreground(ui.White).
+		return ui.Highlight(buttonStyle, ui.Blue, ui.White).
 			Render("Loading...")
 	}
 
@@ -63,9 +60,7 @@ func renderStatusButton(backendState string, isUsingExitNode bool) string {
 
 // Render the locked out warning. Returns static output; should be called conditionally.
 func renderLockedOutWarning(m *model) string {
-	heading := lipgloss.NewStyle().
-		Background(ui.Yellow).
-		Foreground(ui.Black).
+	heading := ui.Highlight(lipgloss.NewStyle(), ui.Yellow, ui.Black).
 		Bold(true).
 		Padding(0, 1).
 		Render("Warning: Locked Out")
@@ -74,7 +69,7 @@ func renderLockedOutWarning(m *model) string {
 
 	lockedOutWarning := lipgloss.NewStyle().
 		Foreground(ui.Yellow).
-		Width(80).
+		Width(min(80, m.terminalWidth)).
 		Align(lipgloss.Center).
 		Render(heading + "\n" + bodyText)
 
@@ -94,21 +89,22 @@ func renderHeader(m *model) string {
 		status.WriteString("Status: ")
 		status.WriteString(renderStatusButton(m.state.BackendState, m.state.CurrentExitNode != nil))
 		if m.state.BackendState == ipn.Running.String() {
-			status.WriteString(lipgloss.NewStyle().
-				Faint(true).
+			hint := ui.Mute(lipgloss.NewStyle()).
 				PaddingLeft(1).
-				Render("(press . to disconnect)"))
+				Render(fmt.Sprintf("(press %s to disconnect)", m.keys.hint(actionToggleConnection)))
+			// Leave the hint out if it doesn't fit.
+			if lipgloss.Width(status.String()+hint) <= m.terminalWidth {
+				status.WriteString(hint)
+			}
 		}
 		status.WriteByte('\n')
 
 		// Extra info; either auth URL or user login name, depending on the backend state.
 		if m.state.User == nil || m.state.User.LoginName == "" {
-			status.WriteString(lipgloss.NewStyle().
-				Faint(true).
+			status.WriteString(ui.Mute(lipgloss.NewStyle()).
 				Render("--"))
 		} else {
-			status.WriteString(lipgloss.NewStyle().
-				Faint(true).
+			status.WriteString(ui.Mute(lipgloss.NewStyle()).
 				Render(m.state.User.LoginName))
 		}
 
@@ -127,41 +123,161 @@ func renderHeader(m *model) string {
 			versions.WriteString("(not connected)")
 		}
 
-		versionsStr = lipgloss.NewStyle().
-			Faint(true).
+		versionsStr = ui.Mute(lipgloss.NewStyle()).
 			Render(versions.String())
 	}
 
+	// On narrow terminals, drop the versions and then the logo to make room for the status.
+	if lipgloss.Width(logo)+lipgloss.Width(statusStr)+lipgloss.Width(versionsStr) > m.terminalWidth {
+		versionsStr = ""
+	}
+	if lipgloss.Width(logo)+lipgloss.Width(statusStr) > m.terminalWidth {
+		logo = ""
+	}
+
 	// Spacer between the left content and the right content.
 	spacer := lipgloss.NewStyle().
-		Width(m.terminalWidth - lipgloss.Width(versionsStr) - lipgloss.Width(statusStr) - lipgloss.Width(logo)).
+		Width(max(m.terminalWidth-lipgloss.Width(versionsStr)-lipgloss.Width(statusStr)-lipgloss.Width(logo), 0)).
 		Render(" ")
 
 	return lipgloss.JoinHorizontal(lipgloss.Center, logo, statusStr, spacer, versionsStr)
 }
 
-// Render a banner/modal for the middle of the screen.
+// Render a banner/modal for the middle of the screen. Text wider than the terminal is wrapped.
 func renderMiddleBanner(m *model, height int, text string) string {
-	divider := lipgloss.NewStyle().
-		Faint(true).
+	if lipgloss.Width(text) > m.terminalWidth {
+		text = lipgloss.NewStyle().
+			Width(m.terminalWidth).
+			Render(text)
+	}
+
+	divider := ui.Mute(lipgloss.NewStyle()).
 		Render(strings.Repeat("=", lipgloss.Width(text)))
 
 	return lipgloss.Place(m.terminalWidth, height, lipgloss.Center, lipgloss.Center,
 		divider+"\n\n"+text+"\n\n"+divider)
 }
 
+// Render the key help overlay for the current context, from the same keymap Update
+// dispatches on.
+func renderHelpOverlay(m *model, height int) string {
+	context := m.keyContext()
+
+	type row struct{ keys, help string }
+	var rows []row
+	for _, binding := range m.keys.bindingsIn(context) {
+		if keys := m.keys.describeIn(binding.action, context); keys != "" {
+			rows = append(rows, row{keys, binding.help})
+		}
+	}
+	if context == keyContextInput {
+		rows = append(rows, row{"other keys", "Type text"})
+	}
+	rows = append(rows, row{emergencyQuitKey, "Quit, always"})
+
+	keysWidth := 0
+	for _, r := range rows {
+		keysWidth = max(keysWidth, lipgloss.Width(r.keys))
+	}
+	keyStyle := lipgloss.NewStyle().
+		Foreground(ui.Primary).
+		Width(keysWidth + 3)
+
+	lines := []string{
+		lipgloss.NewStyle().
+			Bold(true).
+			Render("Keys: " + context.String()),
+		"",
+	}
+	for _, r := range rows {
+		lines = append(lines, keyStyle.Render(r.keys)+r.help)
+	}
+	lines = append(lines,
+		"",
+		ui.Mute(lipgloss.NewStyle()).
+			Render("Press any key to close."),
+	)
+
+	return renderMiddleBanner(m, height, lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// Render the rolling throughput graph above the status bar. Uses two lines, one each for
+// received and sent, or a single combined line if the terminal is short.
+func renderThroughputGraph(m *model) string {
+	rx, tx := m.traffic.historySeries()
+
+	// Scale both series against the same peak so they're visually comparable.
+	var peak float64
+	for i := range rx {
+		peak = max(peak, rx[i], tx[i])
+	}
+
+	labelStyle := ui.Mute(lipgloss.NewStyle()).
+		Width(graphLabelWidth).
+		MaxWidth(m.terminalWidth)
+	graphWidth := max(m.terminalWidth-graphLabelWidth, 0)
+
+	if m.terminalHeight < compactGraphMinHeight {
+		total := make([]float64, len(rx))
+		var totalPeak float64
+		for i := range rx {
+			total[i] = rx[i] + tx[i]
+			totalPeak = max(totalPeak, total[i])
+		}
+
+		return labelStyle.Render("⇅ peak "+ui.FormatRate(totalPeak)) +
+			lipgloss.NewStyle().
+				Foreground(ui.Primary).
+				Render(ui.RenderSparkline(total, totalPeak, graphWidth))
+	}
+
+	rxLine := labelStyle.Render("▼ peak "+ui.FormatRate(peak)) +
+		lipgloss.NewStyle().
+			Foreground(ui.Blue).
+			Render(ui.RenderSparkline(rx, peak, graphWidth))
+	txLine := labelStyle.Render("▲") +
+		lipgloss.NewStyle().
+			Foreground(ui.Green).
+			Render(ui.RenderSparkline(tx, peak, graphWidth))
+
+	return rxLine + "\n" + txLine
+}
+
+// How long a call to tailscaled can run before it's shown in the status bar.
+const slowCallThreshold = 2 * time.Second
+
 // Render the bottom status bar.
 func renderStatusBar(m *model) string {
 	var text string
 
-	if m.statusText == "" && m.canWrite && m.state.BackendState == ipn.Running.String() {
-		// If there's no other status, we're running, and we have write access, show up/down.
+	if call, ok := libts.OldestCall(); ok && time.Since(call.Started) >= slowCallThreshold {
+		// A slow call comes first, since it's probably why nothing else is happening.
+		// The screen is redrawn on every animation tick, so the time keeps counting.
 		text = lipgloss.NewStyle().
-			Faint(true).
+			Bold(true).
+			Foreground(ui.Yellow).
+			Render(fmt.Sprintf("%s Still %s (%ds).", ui.SpinnerFrame(), call.Name, int(time.Since(call.Started).Seconds())))
+		text += lipgloss.NewStyle().
+			Foreground(ui.Yellow).
+			Render(fmt.Sprintf(" Press %s to abort.", m.keys.hint(actionAbort)))
+	} else if m.statusText == "" && libts.DryRun {
+		// In dry-run mode, remind the user that nothing they do is applied.
+		text = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(ui.Yellow).
+			Render("Dry-run mode.")
+		text += lipgloss.NewStyle().
+			Foreground(ui.Yellow).
+			Render(" Changes are shown but not applied.")
+	} else if m.statusText == "" && m.canWrite && m.state.BackendState == ipn.Running.String() {
+		// If there's no other status, we're running, and we have write access, show up/down.
+		text = ui.Mute(lipgloss.NewStyle()).
 			Render(fmt.Sprintf(
-				"▼ %s | %s ▲",
+				"▼ %s (%s) | %s (%s) ▲",
 				ui.FormatBytes(m.state.RxBytes),
+				ui.FormatRate(m.traffic.totalRxRate),
 				ui.FormatBytes(m.state.TxBytes),
+				ui.FormatRate(m.traffic.totalTxRate),
 			))
 	} else if m.statusText == "" && !m.canWrite {
 		// If there's no other status and we don't have write access, show a read-only warning.
@@ -202,9 +318,17 @@ func renderStatusBar(m *model) string {
 			Render(m.statusText)
 	}
 
-	right := lipgloss.NewStyle().
-		Faint(true).
-		Render("press q to quit")
+	right := ui.Mute(lipgloss.NewStyle()).
+		Render(fmt.Sprintf("press %s for help, %s to quit", m.keys.hint(actionHelp), m.keys.hint(actionQuit)))
+
+	// If the text doesn't fit centered between the hints, drop them and let the text wrap
+	// across the whole width instead.
+	if lipgloss.Width(text)+2*lipgloss.Width(right) > m.terminalWidth {
+		return lipgloss.NewStyle().
+			Width(m.terminalWidth).
+			Align(lipgloss.Center).
+			Render(text)
+	}
 
 	left := lipgloss.NewStyle().
 		Width(m.terminalWidth - lipgloss.Width(right)).
@@ -231,6 +355,9 @@ func (m model) View() string {
 
 	// Render the bottom of the page (status bar, error text, etc).
 	bottom := "\n" + renderStatusBar(&m)
+	if m.showGraph && m.state.BackendState == ipn.Running.String() {
+		bottom = "\n" + renderThroughputGraph(&m) + bottom
+	}
 
 	// Now, draw the middle, and make it take up the remaining space.
 	middleHeight := m.terminalHeight - lipgloss.Height(top) - lipgloss.Height(bottom)
@@ -245,7 +372,8 @@ func (m model) View() string {
 	case ipn.Running.String():
 		middle = lipgloss.NewStyle().
 			Height(middleHeight).
-			Render(m.menu.Render())
+			Render(m.menu.Render(m.terminalWidth, middleHeight, m.menuHits))
+		m.menuHits.Y = lipgloss.Height(top)
 
 	case ipn.NeedsMachineAuth.String():
 		// TODO: Figure out what this state actually is so we can be helpful to the user.
@@ -263,7 +391,7 @@ func (m model) View() string {
 
 		if m.state.AuthURL == "" {
 			lines = append(lines,
-				`Press . to authenticate.`,
+				fmt.Sprintf(`Press %s to authenticate.`, m.keys.hint(actionToggleConnection)),
 			)
 		} else {
 			lines = append(lines,
@@ -273,7 +401,7 @@ func (m model) View() string {
 				// We can't open the browser for them if running as the root user on Linux.
 				lines = append(lines,
 					``,
-					`Press . to open in browser.`,
+					fmt.Sprintf(`Press %s to open in browser.`, m.keys.hint(actionToggleConnection)),
 				)
 			}
 		}
@@ -284,7 +412,7 @@ func (m model) View() string {
 		middle = renderMiddleBanner(&m, middleHeight, strings.Join([]string{
 			`The Tailscale daemon isn't running.`,
 			``,
-			`Press . to bring Tailscale up.`,
+			fmt.Sprintf(`Press %s to bring Tailscale up.`, m.keys.hint(actionToggleConnection)),
 		}, "\n"))
 
 	case ipn.NoState.String():
@@ -306,12 +434,20 @@ func (m model) View() string {
 				// We can't open the browser for them if running as the root user on Linux.
 				lines = append(lines,
 					``,
-					`Press . to open in browser.`,
+					fmt.Sprintf(`Press %s to open in browser.`, m.keys.hint(actionToggleConnection)),
 				)
 			}
 			middle = renderMiddleBanner(&m, middleHeight, strings.Join(lines, "\n"))
 		}
 	}
 
+	// Dialogs and the help overlay replace whatever's in the middle.
+	if m.confirm != nil {
+		middle = renderMiddleBanner(&m, middleHeight, m.confirm.Render(m.terminalWidth))
+	}
+	if m.showHelp {
+		middle = renderHelpOverlay(&m, middleHeight)
+	}
+
 	return top + "\n" + middle + "\n" + bottom
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/neuralinkcorp/tsui/libts"
+)
+
+// The parts of the state that `tsui watch` reports changes to.
+type watchSnapshot struct {
+	backendState string
+	exitNode     string
+	user         string
+	// When the key expires, in RFC 3339 format, or "expired" once it has. The snapshot only
+	// changes when the key is renewed or expires. Empty if the key doesn't expire.
+	keyExpiry string
+	// Names of online peers, sorted.
+	onlinePeers []string
+}
+
+func newWatchSnapshot(state *libts.State) watchSnapshot {
+	snapshot := watchSnapshot{
+		backendState: state.BackendState,
+		exitNode:     state.CurrentExitNodeName,
+	}
+	if state.User != nil {
+		snapshot.user = state.User.LoginName
+	}
+	if state.Self != nil && state.Self.KeyExpiry != nil {
+		if time.Until(*state.Self.KeyExpiry) <= 0 {
+			snapshot.keyExpiry = "expired"
+		} else {
+			snapshot.keyExpiry = state.Self.KeyExpiry.UTC().Format(time.RFC3339)
+		}
+	}
+	for _, peer := range state.SortedPeers {
+		if peer.Online {
+			snapshot.onlinePeers = append(snapshot.onlinePeers, libts.PeerName(peer))
+		}
+	}
+	slices.Sort(snapshot.onlinePeers)
+	return snapshot
+}
+
+// A single change reported by `tsui watch`.
+type watchEvent struct {
+	Time time.Time `json:"time"`
+	// What changed: "backend-state", "exit-node", "user", "key-expiry", "peer-online",
+	// "peer-offline", or "error" if the state couldn't be fetched.
+	Kind string `json:"kind"`
+	// Previous value. Omitted for peer events.
+	From string `json:"from,omitempty"`
+	// New value, or the peer name for peer events.
+	To string `json:"to"`
+}
+
+func (e watchEvent) String() string {
+	timestamp := e.Time.Format(time.RFC3339)
+	switch e.Kind {
+	case "peer-online", "peer-offline", "error":
+		return fmt.Sprintf("%s %s %s", timestamp, e.Kind, e.To)
+	}
+	return fmt.Sprintf("%s %s %q -> %q", timestamp, e.Kind, e.From, e.To)
+}
+
+// Compare two snapshots and list the changes between them.
+func diffWatchSnapshots(prev watchSnapshot, next watchSnapshot, now time.Time) []watchEvent {
+	var events []watchEvent
+
+	addChange := func(kind string, from string, to string) {
+		if from != to {
+			events = append(events, watchEvent{Time: now, Kind: kind, From: from, To: to})
+		}
+	}
+	addChange("backend-state", prev.backendState, next.backendState)
+	addChange("exit-node", prev.exitNode, next.exitNode)
+	addChange("user", prev.user, next.user)
+	addChange("key-expiry", prev.keyExpiry, next.keyExpiry)
+
+	for _, peer := range next.onlinePeers {
+		if _, found := slices.BinarySearch(prev.onlinePeers, peer); !found {
+			events = append(events, watchEvent{Time: now, Kind: "peer-online", To: peer})
+		}
+	}
+	for _, peer := range prev.onlinePeers {
+		if _, found := slices.BinarySearch(next.onlinePeers, peer); !found {
+			events = append(events, watchEvent{Time: now, Kind: "peer-offline", To: peer})
+		}
+	}
+
+	return events
+}
+
+// Entrypoint for `tsui watch`: print a line whenever the state changes, until interrupted.
+func runWatch(args []string, defaultInterval time.Duration) error {
+	flags := flag.NewFlagSet("tsui watch", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	jsonOutput := flags.Bool("json", false, "print one JSON object per change")
+	interval := flags.Duration("interval", defaultInterval, "time between state checks")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	if flags.NArg() > 0 {
+		return usageError("watch takes no arguments")
+	}
+	if *interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	encoder := json.NewEncoder(os.Stdout)
+	printEvent := func(event watchEvent) error {
+		if *jsonOutput {
+			return encoder.Encode(event)
+		}
+		_, err := fmt.Println(event)
+		return err
+	}
+
+	var prev *watchSnapshot
+	var lastErr string
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		state, err := libts.GetState(ctx)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+
+		now := time.Now()
+		if err != nil {
+			// Report the daemon becoming unreachable as a state change, but only once.
+			if err.Error() != lastErr {
+				lastErr = err.Error()
+				err := printEvent(watchEvent{Time: now, Kind: "error", To: strings.TrimSpace(lastErr)})
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			lastErr = ""
+			next := newWatchSnapshot(&state)
+
+			// The first snapshot is reported as changes from an empty state.
+			var events []watchEvent
+			if prev == nil {
+				events = diffWatchSnapshots(watchSnapshot{}, next, now)
+			} else {
+				events = diffWatchSnapshots(*prev, next, now)
+			}
+
+			for _, event := range events {
+				err := printEvent(event)
+				if err != nil {
+					return err
+				}
+			}
+			prev = &next
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}